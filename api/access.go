@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+)
+
+// Requested namespaces must all be read by the role. A nil policy reads everything.
+func checkNamespaces(policy *middleware.Policy, namespaces ...string) *httputil.HttpError {
+	if policy == nil {
+		return nil
+	}
+	for _, namespace := range namespaces {
+		if !policy.AllowsNamespace(namespace) {
+			return httputil.NewForbiddenError(fmt.Errorf("namespace %q is not allowed", namespace))
+		}
+	}
+	return nil
+}
+
+// Filtering or aggregating on data would reveal it when masked.
+func checkData(policy *middleware.Policy) *httputil.HttpError {
+	if policy != nil && policy.Masks(models.EntryColumns.Data) {
+		return httputil.NewForbiddenError(fmt.Errorf("data is masked"))
+	}
+	return nil
+}
+
+// Columns parsed from user_agent, masked along with it.
+var userAgentColumns = map[string]bool{
+	models.EntryColumns.UaBrowser: true,
+	models.EntryColumns.UaOs:      true,
+	models.EntryColumns.UaDevice:  true,
+	models.EntryColumns.UaBot:     true,
+}
+
+// Aggregating on columns parsed from user_agent would reveal it when masked.
+func checkUserAgent(policy *middleware.Policy) *httputil.HttpError {
+	if policy != nil && policy.Masks(models.EntryColumns.UserAgent) {
+		return httputil.NewForbiddenError(fmt.Errorf("user_agent is masked"))
+	}
+	return nil
+}
+
+// Namespace prefixes restricting queries without requested namespaces.
+func namespacePrefixes(policy *middleware.Policy, namespaces []string) []string {
+	if policy == nil || len(namespaces) > 0 {
+		return nil
+	}
+	return policy.Namespaces
+}
+
+// Restricts queries of entries to the requested namespaces, or otherwise to the role's
+// namespace prefixes.
+func namespaceMods(policy *middleware.Policy, namespaces []string) ([]qm.QueryMod, *httputil.HttpError) {
+	if err := checkNamespaces(policy, namespaces...); err != nil {
+		return nil, err
+	}
+	if len(namespaces) > 0 {
+		return []qm.QueryMod{models.EntryWhere.Namespace.IN(namespaces)}, nil
+	}
+	if prefixes := namespacePrefixes(policy, namespaces); len(prefixes) > 0 {
+		return []qm.QueryMod{namespacePrefixMod(prefixes)}, nil
+	}
+	return nil, nil
+}
+
+// Restricts the filters to what the role reads.
+func (f *ScanFilters) restrict(policy *middleware.Policy) *httputil.HttpError {
+	if err := checkNamespaces(policy, f.Namespaces...); err != nil {
+		return err
+	}
+	if len(f.DataFilters) > 0 {
+		if err := checkData(policy); err != nil {
+			return err
+		}
+	}
+	f.namespacePrefixes = namespacePrefixes(policy, f.Namespaces)
+	return nil
+}
+
+// Returns a masked copy of the entry, entries may be shared (see tail.Hub).
+func maskEntry(policy *middleware.Policy, e *models.Entry) *models.Entry {
+	if policy == nil || len(policy.Masked) == 0 {
+		return e
+	}
+	masked := *e
+	if policy.Masks(models.EntryColumns.IPAddr) {
+		masked.IPAddr = ""
+	}
+	if policy.Masks(models.EntryColumns.UserAgent) {
+		masked.UserAgent = ""
+		masked.UaBrowser = null.String{}
+		masked.UaOs = null.String{}
+		masked.UaDevice = null.String{}
+		masked.UaBot = null.Bool{}
+	}
+	if policy.Masks(models.EntryColumns.Data) {
+		masked.Data = null.JSON{}
+	}
+	return &masked
+}
+
+// Masks the entries in place, including their projected data paths.
+func maskScanEntries(policy *middleware.Policy, entries []*ScanEntry) {
+	if policy == nil || len(policy.Masked) == 0 {
+		return
+	}
+	for _, e := range entries {
+		e.Entry = maskEntry(policy, e.Entry)
+		if policy.Masks(models.EntryColumns.Data) {
+			for name := range e.Paths {
+				e.Paths[name] = null.JSON{}
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type AccessSuite struct {
+	suite.Suite
+}
+
+func TestAccess(t *testing.T) {
+	suite.Run(t, new(AccessSuite))
+}
+
+func (suite *AccessSuite) TestRestrict() {
+	policy := &middleware.Policy{Namespaces: []string{"archive_"}, Masked: []string{"data"}}
+
+	f := ScanFilters{}
+	suite.Nil(f.restrict(policy))
+	mods, err := f.queryMods()
+	suite.Require().Nil(err)
+	query, args := queries.BuildQuery(models.Entries(mods...).Query)
+	suite.Contains(query, "namespace LIKE ANY($1::text[])")
+	suite.Len(args, 1)
+	suite.True(f.matches(&models.Entry{Namespace: "archive_v1"}))
+	suite.False(f.matches(&models.Entry{Namespace: "archive"}))
+
+	// Requested namespaces are checked instead.
+	f = ScanFilters{Namespaces: []string{"archive_v1"}}
+	suite.Nil(f.restrict(policy))
+	suite.Nil(f.namespacePrefixes)
+
+	f = ScanFilters{Namespaces: []string{"archive_v1", "kmedia"}}
+	httpErr := f.restrict(policy)
+	suite.Require().NotNil(httpErr)
+	suite.Equal(http.StatusForbidden, httpErr.Code)
+
+	f = ScanFilters{DataFilters: []DataPredicate{{Path: "position", Op: "exists"}}}
+	suite.NotNil(f.restrict(policy))
+	suite.Nil(f.restrict(&middleware.Policy{}))
+	suite.Nil(f.restrict(nil))
+}
+
+func (suite *AccessSuite) TestMask() {
+	policy := &middleware.Policy{Masked: []string{"ip_addr", "data"}}
+	e := &models.Entry{IPAddr: "10.0.0.1", UserAgent: "curl", Data: null.JSONFrom([]byte(`{"a": 1}`))}
+
+	masked := maskEntry(policy, e)
+	suite.Equal("", masked.IPAddr)
+	suite.Equal("curl", masked.UserAgent)
+	suite.False(masked.Data.Valid)
+	// Entries are not modified, they may be shared.
+	suite.Equal("10.0.0.1", e.IPAddr)
+	suite.Same(e, maskEntry(&middleware.Policy{}, e))
+	suite.Same(e, maskEntry(nil, e))
+
+	// Columns parsed from user_agent are masked with it.
+	e.UaBrowser, e.UaOs, e.UaDevice, e.UaBot = null.StringFrom("curl"), null.String{}, null.StringFrom("bot"), null.BoolFrom(true)
+	masked = maskEntry(&middleware.Policy{Masked: []string{"user_agent"}}, e)
+	suite.Equal("", masked.UserAgent)
+	suite.False(masked.UaBrowser.Valid)
+	suite.False(masked.UaDevice.Valid)
+	suite.False(masked.UaBot.Valid)
+	suite.Equal("10.0.0.1", masked.IPAddr)
+
+	entries := []*ScanEntry{{Entry: e, Paths: map[string]null.JSON{"data.a": null.JSONFrom([]byte("1"))}}}
+	maskScanEntries(policy, entries)
+	suite.Equal("", entries[0].IPAddr)
+	suite.False(entries[0].Paths["data.a"].Valid)
+}
+
+func (suite *AccessSuite) TestCheckUserAgent() {
+	suite.True(AggregateRequest{GroupBy: []string{"namespace", "ua_device"}}.usesUserAgent())
+	suite.False(AggregateRequest{GroupBy: []string{"namespace"}}.usesUserAgent())
+
+	httpErr := checkUserAgent(middleware.DefaultPolicies()[middleware.ROLE_ANALYST])
+	suite.Require().NotNil(httpErr)
+	suite.Equal(http.StatusForbidden, httpErr.Code)
+	suite.Nil(checkUserAgent(&middleware.Policy{Masked: []string{"ip_addr"}}))
+	suite.Nil(checkUserAgent(nil))
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+const (
+	DEFAULT_AGGREGATE_LIMIT = 1000
+	MAX_AGGREGATE_LIMIT     = 10000
+	MAX_GROUP_BY            = 5
+	MAX_METRICS             = 10
+	TIME_DIMENSION          = "time"
+)
+
+var (
+	groupByColumns = map[string]bool{
+		models.EntryColumns.Namespace:       true,
+		models.EntryColumns.ClientEventType: true,
+		models.EntryColumns.ClientFlowType:  true,
+		models.EntryColumns.UaBrowser:       true,
+		models.EntryColumns.UaOs:            true,
+		models.EntryColumns.UaDevice:        true,
+	}
+	countDistinctColumns = map[string]bool{
+		models.EntryColumns.UserID:          true,
+		models.EntryColumns.ClientSessionID: true,
+		models.EntryColumns.ClientFlowID:    true,
+	}
+	timeBuckets = map[string]bool{"hour": true, "day": true, "week": true, "month": true, "year": true}
+)
+
+// Compiled aggregation, dimension i is selected as gi and metric i as mi.
+type aggregation struct {
+	dimensions []string
+	selects    []string
+	metrics    []string
+	timeBucket bool
+}
+
+func compileAggregation(r AggregateRequest) (*aggregation, error) {
+	if len(r.GroupBy) > MAX_GROUP_BY {
+		return nil, fmt.Errorf("expected at most %d group_by dimensions", MAX_GROUP_BY)
+	}
+	if len(r.Metrics) == 0 {
+		return nil, errors.New("expected at least one metric")
+	}
+	if len(r.Metrics) > MAX_METRICS {
+		return nil, fmt.Errorf("expected at most %d metrics", MAX_METRICS)
+	}
+
+	a := &aggregation{}
+	for _, dimension := range r.GroupBy {
+		var expr string
+		if groupByColumns[dimension] {
+			expr = fmt.Sprintf("\"%s\"", dimension)
+		} else if path, ok := parseProjectedDataPath(dimension); ok {
+			expr = fmt.Sprintf("data #>> '{%s}'", strings.Join(path, ","))
+		} else {
+			return nil, fmt.Errorf("unknown group_by dimension %q", dimension)
+		}
+		a.selects = append(a.selects, fmt.Sprintf("%s AS g%d", expr, len(a.dimensions)))
+		a.dimensions = append(a.dimensions, dimension)
+	}
+	if r.TimeBucket != "" {
+		if !timeBuckets[r.TimeBucket] {
+			return nil, fmt.Errorf("unknown time_bucket %q, expected hour, day, week, month or year", r.TimeBucket)
+		}
+		a.selects = append(a.selects, fmt.Sprintf("date_trunc('%s', created_at AT TIME ZONE 'UTC') AS g%d", r.TimeBucket, len(a.dimensions)))
+		a.dimensions = append(a.dimensions, TIME_DIMENSION)
+		a.timeBucket = true
+	}
+
+	for i, metric := range r.Metrics {
+		var expr string
+		switch metric.Op {
+		case "count":
+			expr = "count(*)"
+		case "count_distinct":
+			if !countDistinctColumns[metric.Field] {
+				return nil, fmt.Errorf("unsupported count_distinct field %q, expected user_id, client_session_id or client_flow_id", metric.Field)
+			}
+			expr = fmt.Sprintf("count(DISTINCT \"%s\")", metric.Field)
+		case "min", "max", "sum", "avg":
+			path, ok := parseProjectedDataPath(metric.Field)
+			if !ok {
+				return nil, fmt.Errorf("expected %s field to be a data path, e.g., data.position", metric.Op)
+			}
+			p := strings.Join(path, ",")
+			expr = fmt.Sprintf("%s(CASE WHEN jsonb_typeof(data #> '{%s}') = 'number' THEN (data #>> '{%s}')::numeric END)::float8", metric.Op, p, p)
+		default:
+			return nil, fmt.Errorf("unknown metric op %q, expected count, count_distinct, min, max, sum or avg", metric.Op)
+		}
+		a.selects = append(a.selects, fmt.Sprintf("%s AS m%d", expr, i))
+		a.metrics = append(a.metrics, metric.name())
+	}
+	return a, nil
+}
+
+// Whether data paths are grouped by or aggregated.
+func (r AggregateRequest) usesUserAgent() bool {
+	for _, dimension := range r.GroupBy {
+		if userAgentColumns[dimension] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AggregateRequest) usesData() bool {
+	for _, dimension := range r.GroupBy {
+		if _, ok := parseProjectedDataPath(dimension); ok {
+			return true
+		}
+	}
+	for _, metric := range r.Metrics {
+		if _, ok := parseProjectedDataPath(metric.Field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m AggregateMetric) name() string {
+	if m.Field == "" {
+		return m.Op
+	}
+	return fmt.Sprintf("%s(%s)", m.Op, m.Field)
+}
+
+func (a *aggregation) queryMods(filterMods []qm.QueryMod, limit int) []qm.QueryMod {
+	mods := []qm.QueryMod{qm.Select(a.selects...)}
+	mods = append(mods, filterMods...)
+	if len(a.dimensions) > 0 {
+		groups := make([]string, len(a.dimensions))
+		for i := range a.dimensions {
+			groups[i] = fmt.Sprintf("g%d", i)
+		}
+		mods = append(mods, qm.GroupBy(strings.Join(groups, ", ")), qm.OrderBy(strings.Join(groups, ", ")))
+	}
+	// One more row tells whether the result was truncated.
+	return append(mods, qm.Limit(limit+1))
+}
+
+func (a *aggregation) scanRows(rows *sql.Rows) ([]AggregateRow, error) {
+	result := []AggregateRow{}
+	for rows.Next() {
+		dimensions := make([]null.String, len(a.dimensions))
+		var bucket null.Time
+		metrics := make([]null.Float64, len(a.metrics))
+		ptrs := []interface{}{}
+		for i := range dimensions {
+			if a.timeBucket && i == len(dimensions)-1 {
+				ptrs = append(ptrs, &bucket)
+			} else {
+				ptrs = append(ptrs, &dimensions[i])
+			}
+		}
+		for i := range metrics {
+			ptrs = append(ptrs, &metrics[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, pkgerr.Wrap(err, "scan aggregate row")
+		}
+
+		row := AggregateRow{Group: map[string]interface{}{}, Metrics: map[string]interface{}{}}
+		for i, dimension := range a.dimensions {
+			if dimension == TIME_DIMENSION {
+				row.Group[dimension] = bucket.Time.UTC().Format(time.RFC3339)
+			} else {
+				row.Group[dimension] = dimensions[i].Ptr()
+			}
+		}
+		for i, metric := range a.metrics {
+			row.Metrics[metric] = metrics[i].Ptr()
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// AggregateHandler counts entries matching the scan filters grouped by the requested dimensions.
+// To guard the DB a bounded created_at range is required, the number of groups is limited
+// and the query runs with a statement timeout.
+// Aggregations of whole UTC days by namespace and client_event_type are served from
+// daily rollups when these are up to date, distinct users are then estimated.
+func AggregateHandler(c *gin.Context) {
+	r := AggregateRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	resp, err := handleAggregate(c, r)
+	concludeRequest(c, resp, err)
+}
+
+func handleAggregate(c *gin.Context, r AggregateRequest) (*AggregateResponse, *httputil.HttpError) {
+	if !r.From.Valid || !r.To.Valid {
+		return nil, httputil.NewBadRequestError(errors.New("expected both from and to to be set"))
+	}
+	if !r.From.Time.Before(r.To.Time) {
+		return nil, httputil.NewBadRequestError(errors.New("expected from to be before to"))
+	}
+	if r.To.Time.Sub(r.From.Time) > common.Config.AggregateMaxRange {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected range of at most %s", common.Config.AggregateMaxRange))
+	}
+	limit := DEFAULT_AGGREGATE_LIMIT
+	if r.Limit != 0 {
+		limit = r.Limit
+	}
+	if limit < 0 || limit > MAX_AGGREGATE_LIMIT {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected limit of at most %d", MAX_AGGREGATE_LIMIT))
+	}
+
+	policy := middleware.GetPolicy(c)
+	if err := r.ScanFilters.restrict(policy); err != nil {
+		return nil, err
+	}
+	if r.usesData() {
+		if err := checkData(policy); err != nil {
+			return nil, err
+		}
+	}
+	if r.usesUserAgent() {
+		if err := checkUserAgent(policy); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := compileAggregation(r)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	filterMods, err := r.ScanFilters.queryMods()
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+
+	db := c.MustGet("DB").(*sql.DB)
+	log := c.MustGet("LOGGER").(zerolog.Logger)
+
+	if rollupsSupport(r) {
+		covered, err := rollup.DailyRollups.CoveredUntil(db)
+		if err != nil {
+			log.Warn().Err(err).Msg("Daily rollups progress, aggregating entries")
+		} else if !r.To.Time.After(covered) {
+			rows, err := aggregateRollups(db, r, limit)
+			if err != nil {
+				return nil, httputil.NewInternalError(err)
+			}
+			return newAggregateResponse(rows, limit, SOURCE_ROLLUPS), nil
+		}
+	}
+
+	var rows []AggregateRow
+	err = sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		timeout := common.Config.AggregateTimeout.Milliseconds()
+		if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)); err != nil {
+			return pkgerr.Wrap(err, "set statement_timeout")
+		}
+		result, err := models.Entries(a.queryMods(filterMods, limit)...).Query.Query(tx)
+		if err != nil {
+			return pkgerr.Wrap(err, "aggregate query")
+		}
+		defer result.Close()
+		rows, err = a.scanRows(result)
+		return err
+	})
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+
+	return newAggregateResponse(rows, limit, SOURCE_ENTRIES), nil
+}
+
+func newAggregateResponse(rows []AggregateRow, limit int, source string) *AggregateResponse {
+	resp := &AggregateResponse{Rows: rows, Source: source}
+	if len(rows) > limit {
+		resp.Rows = rows[:limit]
+		resp.Truncated = true
+	}
+	return resp
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type AggregateSuite struct {
+	suite.Suite
+}
+
+func TestAggregate(t *testing.T) {
+	suite.Run(t, new(AggregateSuite))
+}
+
+func (suite *AggregateSuite) TestCompile() {
+	a, err := compileAggregation(AggregateRequest{
+		GroupBy:    []string{"namespace", "data.search.query"},
+		TimeBucket: "hour",
+		Metrics: []AggregateMetric{
+			{Op: "count"},
+			{Op: "count_distinct", Field: "user_id"},
+			{Op: "max", Field: "data.position"},
+		},
+	})
+	suite.Require().Nil(err)
+	suite.Equal([]string{"namespace", "data.search.query", "time"}, a.dimensions)
+	suite.Equal([]string{"count", "count_distinct(user_id)", "max(data.position)"}, a.metrics)
+
+	sql, _ := queries.BuildQuery(models.Entries(a.queryMods([]qm.QueryMod{qm.Where("TRUE")}, 10)...).Query)
+	suite.Contains(sql, "SELECT \"namespace\" AS g0, data #>> '{search,query}' AS g1, date_trunc('hour', created_at AT TIME ZONE 'UTC') AS g2, count(*) AS m0")
+	suite.Contains(sql, "GROUP BY g0, g1, g2 ORDER BY g0, g1, g2 LIMIT 11")
+}
+
+func (suite *AggregateSuite) TestInvalid() {
+	_, err := compileAggregation(AggregateRequest{Metrics: []AggregateMetric{{Op: "count_distinct", Field: "ip_addr"}}})
+	suite.NotNil(err)
+	_, err = compileAggregation(AggregateRequest{GroupBy: []string{"user_agent"}, Metrics: []AggregateMetric{{Op: "count"}}})
+	suite.EqualError(err, `unknown group_by dimension "user_agent"`)
+	_, err = compileAggregation(AggregateRequest{})
+	suite.NotNil(err)
+}
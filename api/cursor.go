@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/signedtoken"
+)
+
+var (
+	errInvalidCursor   = errors.New("invalid cursor")
+	errCursorFilters   = errors.New("cursor was issued for different filters")
+	errCursorWithRawId = errors.New("expected either cursor or id, not both")
+)
+
+// Position in a scan. Entries strictly after it in the scan direction are returned.
+type scanCursor struct {
+	Id        string    `json:"id"`
+	CreatedAt null.Time `json:"created_at,omitempty"`
+	Back      bool      `json:"back,omitempty"`
+	// Digest of the filters and order the cursor was issued for.
+	Filters string `json:"filters"`
+}
+
+// Digest of everything that must not change while paging.
+func scanDigest(filters ScanFilters, orderBy string) (string, error) {
+	b, err := json.Marshal(struct {
+		Filters ScanFilters `json:"filters"`
+		OrderBy string      `json:"order_by"`
+	}{filters, orderBy})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:16]), nil
+}
+
+func decodeScanCursor(secret []byte, token string, digest string) (*scanCursor, error) {
+	cursor := &scanCursor{}
+	if err := signedtoken.Verify(secret, token, cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	if cursor.Filters != digest {
+		return nil, errCursorFilters
+	}
+	return cursor, nil
+}
+
+func encodeScanCursor(secret []byte, entry *models.Entry, back bool, orderBy string, digest string) (string, error) {
+	cursor := scanCursor{Id: entry.ID, Back: back, Filters: digest}
+	if orderBy == models.EntryColumns.CreatedAt {
+		cursor.CreatedAt = null.TimeFrom(entry.CreatedAt)
+	}
+	return signedtoken.Sign(secret, cursor)
+}
+
+// Condition selecting entries after the cursor position in its direction.
+func (cursor *scanCursor) queryMod(orderBy string) qm.QueryMod {
+	op := ">"
+	if cursor.Back {
+		op = "<"
+	}
+	if orderBy == models.EntryColumns.CreatedAt {
+		createdAt := time.Time{}
+		if cursor.CreatedAt.Valid {
+			createdAt = cursor.CreatedAt.Time
+		}
+		return qm.And("(created_at, id) "+op+" (?, ?)", createdAt, cursor.Id)
+	}
+	return qm.And("id "+op+" ?", cursor.Id)
+}
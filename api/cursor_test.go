@@ -0,0 +1,56 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type CursorSuite struct {
+	suite.Suite
+}
+
+func TestCursor(t *testing.T) {
+	suite.Run(t, new(CursorSuite))
+}
+
+func (suite *CursorSuite) TestRoundTrip() {
+	secret := []byte("secret")
+	filters := ScanFilters{Namespaces: []string{"archive"}}
+	digest, err := scanDigest(filters, models.EntryColumns.CreatedAt)
+	suite.Require().Nil(err)
+
+	createdAt := time.Date(2025, 1, 14, 10, 0, 0, 0, time.UTC)
+	token, err := encodeScanCursor(secret, &models.Entry{ID: "2rd", CreatedAt: createdAt}, true, models.EntryColumns.CreatedAt, digest)
+	suite.Require().Nil(err)
+
+	cursor, err := decodeScanCursor(secret, token, digest)
+	suite.Require().Nil(err)
+	suite.Equal("2rd", cursor.Id)
+	suite.True(cursor.Back)
+	suite.True(cursor.CreatedAt.Time.Equal(createdAt))
+
+	sql, args := queries.BuildQuery(models.Entries(cursor.queryMod(models.EntryColumns.CreatedAt)).Query)
+	suite.Contains(sql, "(created_at, id) < ($1, $2)")
+	suite.Equal("2rd", args[1])
+}
+
+func (suite *CursorSuite) TestRejected() {
+	secret := []byte("secret")
+	digest, err := scanDigest(ScanFilters{Namespaces: []string{"archive"}}, models.EntryColumns.ID)
+	suite.Require().Nil(err)
+	token, err := encodeScanCursor(secret, &models.Entry{ID: "2rd"}, false, models.EntryColumns.ID, digest)
+	suite.Require().Nil(err)
+
+	other, err := scanDigest(ScanFilters{Namespaces: []string{"kmedia"}}, models.EntryColumns.ID)
+	suite.Require().Nil(err)
+	_, err = decodeScanCursor(secret, token, other)
+	suite.Equal(errCursorFilters, err)
+
+	_, err = decodeScanCursor([]byte("other"), token, digest)
+	suite.Equal(errInvalidCursor, err)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+)
+
+const MAX_LOOKUP_IDS = 1000
+
+// EntryHandler returns a single entry by id.
+func EntryHandler(c *gin.Context) {
+	r := EntriesRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	resp, err := handleEntry(c.MustGet("DB").(*sql.DB), middleware.GetPolicy(c), c.Param("id"), r.Fields)
+	concludeRequest(c, resp, err)
+}
+
+func handleEntry(db *sql.DB, policy *middleware.Policy, id string, fields []string) (*ScanEntry, *httputil.HttpError) {
+	p, err := parseFields(fields)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	// Entries of namespaces the role doesn't read are not found.
+	mods, httpErr := namespaceMods(policy, nil)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	if len(p.paths) == 0 && len(mods) == 0 {
+		entry, err := models.FindEntry(db, id, p.columns...)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, httputil.NewNotFoundError()
+			}
+			return nil, httputil.NewInternalError(err)
+		}
+		return &ScanEntry{Entry: maskEntry(policy, entry)}, nil
+	}
+
+	mods = append(mods, models.EntryWhere.ID.EQ(id))
+	entries, err := p.queryEntries(db, mods...)
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+	if len(entries) == 0 {
+		return nil, httputil.NewNotFoundError()
+	}
+	maskScanEntries(policy, entries)
+	return entries[0], nil
+}
+
+// EntriesHandler looks up entries by ids and client_event_ids given as query parameters.
+func EntriesHandler(c *gin.Context) {
+	r := EntriesRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	resp, err := handleEntries(c.MustGet("DB").(*sql.DB), middleware.GetPolicy(c), r)
+	concludeRequest(c, resp, err)
+}
+
+// EntriesLookupHandler is EntriesHandler with a JSON body, for batches too large for a URL.
+func EntriesLookupHandler(c *gin.Context) {
+	r := EntriesRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	resp, err := handleEntries(c.MustGet("DB").(*sql.DB), middleware.GetPolicy(c), r)
+	concludeRequest(c, resp, err)
+}
+
+func handleEntries(db *sql.DB, policy *middleware.Policy, r EntriesRequest) (*EntriesResponse, *httputil.HttpError) {
+	if len(r.Ids) == 0 && len(r.ClientEventIds) == 0 {
+		return nil, httputil.NewBadRequestError(errors.New("expected ids or client_event_ids"))
+	}
+	if len(r.Ids)+len(r.ClientEventIds) > MAX_LOOKUP_IDS {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected at most %d ids and client_event_ids", MAX_LOOKUP_IDS))
+	}
+	p, err := parseFields(r.Fields)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	p.requireColumns(r)
+	// Entries of namespaces the role doesn't read are missing.
+	restriction, httpErr := namespaceMods(policy, nil)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	// The namespace only limits the client_event_id lookup, ids are unique.
+	clientEventRestriction := restriction
+	if r.Namespace != "" {
+		if clientEventRestriction, httpErr = namespaceMods(policy, []string{r.Namespace}); httpErr != nil {
+			return nil, httpErr
+		}
+	}
+
+	resp := &EntriesResponse{Entries: []*ScanEntry{}, Missing: []string{}}
+	if len(r.Ids) > 0 {
+		mods := append([]qm.QueryMod{models.EntryWhere.ID.IN(r.Ids)}, restriction...)
+		entries, err := p.queryEntries(db, append(mods, qm.OrderBy(models.EntryColumns.ID))...)
+		if err != nil {
+			return nil, httputil.NewInternalError(err)
+		}
+		found := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			found[e.ID] = true
+		}
+		resp.Entries = append(resp.Entries, entries...)
+		resp.Missing = append(resp.Missing, missing(r.Ids, found)...)
+	}
+
+	if len(r.ClientEventIds) > 0 {
+		mods := []qm.QueryMod{
+			qm.WhereIn(fmt.Sprintf("%s in ?", models.EntryColumns.ClientEventID), ToInterfaceSlice(r.ClientEventIds)...),
+			qm.OrderBy(models.EntryColumns.ID),
+		}
+		entries, err := p.queryEntries(db, append(mods, clientEventRestriction...)...)
+		if err != nil {
+			return nil, httputil.NewInternalError(err)
+		}
+		found := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			found[e.ClientEventID.String] = true
+		}
+		resp.Entries = append(resp.Entries, entries...)
+		resp.Missing = append(resp.Missing, missing(r.ClientEventIds, found)...)
+	}
+	maskScanEntries(policy, resp.Entries)
+	return resp, nil
+}
+
+// Adds the columns needed to tell which of the requested entries were found.
+func (p *projection) requireColumns(r EntriesRequest) {
+	if len(p.columns) == 0 && len(p.paths) == 0 {
+		return
+	}
+	if len(r.Ids) > 0 && !contains(p.columns, models.EntryColumns.ID) {
+		p.columns = append(p.columns, models.EntryColumns.ID)
+	}
+	if len(r.ClientEventIds) > 0 && !contains(p.columns, models.EntryColumns.ClientEventID) {
+		p.columns = append(p.columns, models.EntryColumns.ClientEventID)
+	}
+}
+
+func missing(requested []string, found map[string]bool) []string {
+	result := []string{}
+	for _, id := range requested {
+		if !found[id] {
+			result = append(result, id)
+			// Report repeated ids once.
+			found[id] = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+)
+
+type EntriesSuite struct {
+	suite.Suite
+}
+
+func TestEntries(t *testing.T) {
+	suite.Run(t, new(EntriesSuite))
+}
+
+func (suite *EntriesSuite) TestRoutes() {
+	common.Init()
+	gin.SetMode(gin.TestMode)
+	suite.NotPanics(func() { SetupRoutes(gin.New(), nil) })
+}
+
+func (suite *EntriesSuite) TestRequireColumns() {
+	p, err := parseFields([]string{"namespace", "data.query"})
+	suite.Require().Nil(err)
+	p.requireColumns(EntriesRequest{Ids: []string{"a"}, ClientEventIds: []string{"b"}})
+	suite.Equal([]string{"namespace", "id", "client_event_id"}, p.columns)
+
+	// All columns are selected anyway.
+	p, err = parseFields(nil)
+	suite.Require().Nil(err)
+	p.requireColumns(EntriesRequest{Ids: []string{"a"}})
+	suite.Empty(p.columns)
+}
+
+func (suite *EntriesSuite) TestMissing() {
+	found := map[string]bool{"a": true}
+	suite.Equal([]string{"b", "c"}, missing([]string{"a", "b", "c", "b"}, found))
+	suite.Equal([]string{}, missing([]string{"a"}, map[string]bool{"a": true}))
+}
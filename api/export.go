@@ -0,0 +1,311 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+)
+
+const (
+	EXPORT_FETCH_SIZE      = 1000
+	EXPORT_CHECKPOINT_ROWS = 10000
+	EXPORT_CURSOR_TRAILER  = "X-Export-Cursor"
+	EXPORT_ERROR_TRAILER   = "X-Export-Error"
+	MIME_NDJSON            = "application/x-ndjson"
+	MIME_CSV               = "text/csv"
+)
+
+// Writes exported entries in a specific format.
+type exportWriter interface {
+	Begin(columns []string) error
+	Write(entry *ScanEntry) error
+	Checkpoint(id string, done bool) error
+	Error(err error)
+	Flush() error
+}
+
+// ExportHandler streams entries ordered by id from a DB cursor as NDJSON or CSV
+// (by the Accept header) in constant memory. The id of the last written entry
+// is sent in the X-Export-Cursor trailer and, for NDJSON, in periodic
+// checkpoint lines. Pass it as id to resume the export.
+func ExportHandler(c *gin.Context) {
+	r := ExportRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	p, err := parseFields(r.Fields)
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewBadRequestError(err))
+		return
+	}
+	// The id is required for resuming.
+	p.require(models.EntryColumns.ID)
+	policy := middleware.GetPolicy(c)
+	if err := r.ScanFilters.restrict(policy); err != nil {
+		concludeRequest(c, nil, err)
+		return
+	}
+	mods, err := r.ScanFilters.queryMods()
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewBadRequestError(err))
+		return
+	}
+	if r.Id != "" {
+		mods = append(mods, qm.And("id > ?", r.Id))
+	}
+	if exprs := p.selectExprs(); exprs != nil {
+		mods = append([]qm.QueryMod{qm.Select(exprs...)}, mods...)
+	}
+	mods = append(mods, qm.OrderBy("id asc"))
+	if r.Limit > 0 {
+		mods = append(mods, qm.Limit(r.Limit))
+	}
+	query, args := queries.BuildQuery(models.Entries(mods...).Query)
+
+	var w exportWriter
+	switch c.NegotiateFormat(MIME_NDJSON, MIME_CSV) {
+	case MIME_CSV:
+		c.Header("Content-Type", MIME_CSV)
+		w = newCSVExportWriter(c.Writer, p)
+	default:
+		c.Header("Content-Type", MIME_NDJSON)
+		w = &ndjsonExportWriter{w: c.Writer, enc: json.NewEncoder(c.Writer)}
+	}
+	c.Header("Trailer", fmt.Sprintf("%s, %s", EXPORT_CURSOR_TRAILER, EXPORT_ERROR_TRAILER))
+	c.Status(http.StatusOK)
+
+	db := c.MustGet("DB").(*sql.DB)
+	log := c.MustGet("LOGGER").(zerolog.Logger)
+	lastId, err := export(c, db, p, policy, query, args, w)
+	if lastId != "" {
+		c.Writer.Header().Set(EXPORT_CURSOR_TRAILER, lastId)
+	}
+	if err != nil {
+		// Headers were already sent, report the error in the stream.
+		log.Error().Err(err).Str("cursor", lastId).Msg("Export failed")
+		w.Error(err)
+		c.Writer.Header().Set(EXPORT_ERROR_TRAILER, strings.ReplaceAll(err.Error(), "\n", " "))
+		w.Flush()
+	}
+}
+
+func export(c *gin.Context, db *sql.DB, p *projection, policy *middleware.Policy, query string, args []interface{}, w exportWriter) (string, error) {
+	tx, err := db.BeginTx(c.Request.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", pkgerr.Wrap(err, "begin tx")
+	}
+	// Read only, nothing to commit.
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("DECLARE export_cursor NO SCROLL CURSOR FOR %s", query), args...); err != nil {
+		return "", pkgerr.Wrap(err, "declare cursor")
+	}
+
+	lastId := ""
+	count := 0
+	// Rows of every fetch have the same columns, the mapping is bound on the first one.
+	var mapping []uint64
+	for {
+		rows, err := tx.Query(fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", EXPORT_FETCH_SIZE))
+		if err != nil {
+			return lastId, pkgerr.Wrap(err, "fetch cursor")
+		}
+		if mapping == nil {
+			columns, err := rows.Columns()
+			if err != nil {
+				rows.Close()
+				return lastId, pkgerr.Wrap(err, "rows.Columns")
+			}
+			if mapping, err = p.bindMapping(columns); err != nil {
+				rows.Close()
+				return lastId, err
+			}
+			if err := w.Begin(columns); err != nil {
+				rows.Close()
+				return lastId, err
+			}
+		}
+
+		fetched := 0
+		for rows.Next() {
+			entry, err := p.scanEntry(rows, mapping)
+			if err != nil {
+				rows.Close()
+				return lastId, err
+			}
+			maskScanEntries(policy, []*ScanEntry{entry})
+			if err := w.Write(entry); err != nil {
+				rows.Close()
+				return lastId, err
+			}
+			lastId = entry.ID
+			fetched++
+			count++
+			if count%EXPORT_CHECKPOINT_ROWS == 0 {
+				if err := w.Checkpoint(lastId, false); err != nil {
+					rows.Close()
+					return lastId, err
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return lastId, pkgerr.Wrap(err, "iterate cursor")
+		}
+		if err := w.Flush(); err != nil {
+			return lastId, err
+		}
+		if fetched < EXPORT_FETCH_SIZE {
+			break
+		}
+	}
+
+	if err := w.Checkpoint(lastId, true); err != nil {
+		return lastId, err
+	}
+	return lastId, w.Flush()
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+type ndjsonExportWriter struct {
+	w   gin.ResponseWriter
+	enc *json.Encoder
+}
+
+type exportCheckpoint struct {
+	Checkpoint string `json:"checkpoint"`
+	Done       bool   `json:"done,omitempty"`
+}
+
+func (n *ndjsonExportWriter) Begin(columns []string) error {
+	return nil
+}
+
+func (n *ndjsonExportWriter) Write(entry *ScanEntry) error {
+	return n.enc.Encode(entry)
+}
+
+func (n *ndjsonExportWriter) Checkpoint(id string, done bool) error {
+	return n.enc.Encode(exportCheckpoint{Checkpoint: id, Done: done})
+}
+
+func (n *ndjsonExportWriter) Error(err error) {
+	n.enc.Encode(gin.H{"status": "error", "error": err.Error()})
+}
+
+func (n *ndjsonExportWriter) Flush() error {
+	n.w.Flush()
+	return nil
+}
+
+type csvExportWriter struct {
+	w       gin.ResponseWriter
+	csv     *csv.Writer
+	p       *projection
+	mapping []uint64
+	header  []string
+}
+
+func newCSVExportWriter(w gin.ResponseWriter, p *projection) *csvExportWriter {
+	return &csvExportWriter{w: w, csv: csv.NewWriter(w), p: p}
+}
+
+func (cw *csvExportWriter) Begin(columns []string) error {
+	entryCols := columns[:len(columns)-len(cw.p.paths)]
+	mapping, err := queries.BindMapping(entryType, entryMapping, entryCols)
+	if err != nil {
+		return pkgerr.Wrap(err, "bind mapping")
+	}
+	cw.mapping = mapping
+	cw.header = columns
+	return cw.csv.Write(columns)
+}
+
+func (cw *csvExportWriter) Write(entry *ScanEntry) error {
+	values := queries.ValuesFromMapping(reflect.Indirect(reflect.ValueOf(entry.Entry)), cw.mapping)
+	record := make([]string, 0, len(cw.header))
+	for _, v := range values {
+		record = append(record, csvValue(v))
+	}
+	for _, pp := range cw.p.paths {
+		record = append(record, csvValue(entry.Paths[pp.name]))
+	}
+	return cw.csv.Write(record)
+}
+
+// CSV can't carry checkpoints, the cursor is sent in the trailer.
+func (cw *csvExportWriter) Checkpoint(id string, done bool) error {
+	return nil
+}
+
+func (cw *csvExportWriter) Error(err error) {}
+
+func (cw *csvExportWriter) Flush() error {
+	cw.csv.Flush()
+	cw.w.Flush()
+	return cw.csv.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case null.String:
+		return v.String
+	case null.Bool:
+		if !v.Valid {
+			return ""
+		}
+		return strconv.FormatBool(v.Bool)
+	case null.Int:
+		if !v.Valid {
+			return ""
+		}
+		return strconv.Itoa(v.Int)
+	case null.Int64:
+		if !v.Valid {
+			return ""
+		}
+		return strconv.FormatInt(v.Int64, 10)
+	case null.Time:
+		if !v.Valid {
+			return ""
+		}
+		return v.Time.Format(time.RFC3339Nano)
+	case null.JSON:
+		if !v.Valid {
+			return ""
+		}
+		return string(v.JSON)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type ExportSuite struct {
+	suite.Suite
+}
+
+func TestExport(t *testing.T) {
+	suite.Run(t, new(ExportSuite))
+}
+
+func (suite *ExportSuite) TestCSVValue() {
+	suite.Equal("archive", csvValue("archive"))
+	suite.Equal("2025-01-14T10:00:00.5Z", csvValue(time.Date(2025, 1, 14, 10, 0, 0, 500000000, time.UTC)))
+	suite.Equal("", csvValue(null.String{}))
+	suite.Equal("flow", csvValue(null.StringFrom("flow")))
+	suite.Equal("", csvValue(null.JSON{}))
+	suite.Equal(`{"a":1}`, csvValue(null.JSONFrom([]byte(`{"a":1}`))))
+	suite.Equal("", csvValue(null.Bool{}))
+	suite.Equal("true", csvValue(null.BoolFrom(true)))
+	suite.Equal("", csvValue(null.Time{}))
+	suite.Equal("2025-01-14T10:00:00Z", csvValue(null.TimeFrom(time.Date(2025, 1, 14, 10, 0, 0, 0, time.UTC))))
+	suite.Equal("", csvValue(null.Int{}))
+	suite.Equal("3", csvValue(null.IntFrom(3)))
+}
+
+func (suite *ExportSuite) TestCSVExportWriter() {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	p, err := parseFields([]string{"id", "ua_device", "ua_bot", "data.query"})
+	suite.Require().Nil(err)
+
+	cw := newCSVExportWriter(c.Writer, p)
+	suite.Require().Nil(cw.Begin([]string{"id", "ua_device", "ua_bot", "data.query"}))
+	bot := &ScanEntry{
+		Entry: &models.Entry{ID: "2EHqDQdm3ZHTHWw8jGqzjcvBg1P", UaDevice: null.StringFrom("bot"), UaBot: null.BoolFrom(true)},
+		Paths: map[string]null.JSON{"data.query": null.JSONFrom([]byte(`"kabbalah"`))},
+	}
+	suite.Require().Nil(cw.Write(bot))
+	suite.Require().Nil(cw.Write(&ScanEntry{Entry: &models.Entry{ID: "2EHqDQdm3ZHTHWw8jGqzjcvBg1Q"}}))
+	suite.Require().Nil(cw.Flush())
+	suite.Equal("id,ua_device,ua_bot,data.query\n2EHqDQdm3ZHTHWw8jGqzjcvBg1P,bot,true,\"\"\"kabbalah\"\"\"\n2EHqDQdm3ZHTHWw8jGqzjcvBg1Q,,,\n", w.Body.String())
+}
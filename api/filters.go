@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Builds the WHERE clause of the filters, starting with a TRUE condition so mods can be appended with qm.And.
+// Errors are due to invalid filters.
+func (f ScanFilters) queryMods() ([]qm.QueryMod, error) {
+	mods := []qm.QueryMod{qm.Where("TRUE")}
+	if len(f.Namespaces) > 0 {
+		mods = append(mods, qm.AndIn("namespace in ?", ToInterfaceSlice(f.Namespaces)...))
+	}
+	if len(f.namespacePrefixes) > 0 {
+		mods = append(mods, namespacePrefixMod(f.namespacePrefixes))
+	}
+	if len(f.UserIds) > 0 {
+		mods = append(mods, qm.AndIn("user_id in ?", ToInterfaceSlice(f.UserIds)...))
+	}
+	if len(f.EventTypes) > 0 {
+		mods = append(mods, qm.AndIn("client_event_type in ?", ToInterfaceSlice(f.EventTypes)...))
+	}
+	if len(f.ClientEventIds) > 0 {
+		mods = append(mods, qm.AndIn("client_event_id in ?", ToInterfaceSlice(f.ClientEventIds)...))
+	}
+	if len(f.ClientFlowIds) > 0 {
+		mods = append(mods, qm.AndIn("client_flow_id in ?", ToInterfaceSlice(f.ClientFlowIds)...))
+	}
+	if len(f.ClientFlowTypes) > 0 {
+		mods = append(mods, qm.AndIn("client_flow_type in ?", ToInterfaceSlice(f.ClientFlowTypes)...))
+	}
+	if len(f.ClientSessionIds) > 0 {
+		mods = append(mods, qm.AndIn("client_session_id in ?", ToInterfaceSlice(f.ClientSessionIds)...))
+	}
+	if f.Keycloak.Valid {
+		if f.Keycloak.Bool {
+			mods = append(mods, qm.And(fmt.Sprintf("user_id not like '%s%%'", CLIENT_USER_ID_PREFIX)))
+		} else {
+			mods = append(mods, qm.And(fmt.Sprintf("user_id like '%s%%'", CLIENT_USER_ID_PREFIX)))
+		}
+	}
+	if f.From.Valid {
+		mods = append(mods, qm.And("created_at >= ?", f.From.Time))
+	}
+	if f.To.Valid {
+		mods = append(mods, qm.And("created_at < ?", f.To.Time))
+	}
+	if len(f.DataFilters) > MAX_DATA_PREDICATES {
+		return nil, fmt.Errorf("expected at most %d data filters", MAX_DATA_PREDICATES)
+	}
+	for _, p := range f.DataFilters {
+		mod, err := p.queryMod()
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+// Checks the entry against the filters in memory, data filters are not supported.
+func (f ScanFilters) matches(e *models.Entry) bool {
+	if len(f.Namespaces) > 0 && !contains(f.Namespaces, e.Namespace) {
+		return false
+	}
+	if len(f.namespacePrefixes) > 0 && !hasAnyPrefix(e.Namespace, f.namespacePrefixes) {
+		return false
+	}
+	if len(f.UserIds) > 0 && !contains(f.UserIds, e.UserID) {
+		return false
+	}
+	if len(f.EventTypes) > 0 && !contains(f.EventTypes, e.ClientEventType) {
+		return false
+	}
+	if len(f.ClientEventIds) > 0 && !containsNull(f.ClientEventIds, e.ClientEventID) {
+		return false
+	}
+	if len(f.ClientFlowIds) > 0 && !containsNull(f.ClientFlowIds, e.ClientFlowID) {
+		return false
+	}
+	if len(f.ClientFlowTypes) > 0 && !containsNull(f.ClientFlowTypes, e.ClientFlowType) {
+		return false
+	}
+	if len(f.ClientSessionIds) > 0 && !containsNull(f.ClientSessionIds, e.ClientSessionID) {
+		return false
+	}
+	if f.Keycloak.Valid && f.Keycloak.Bool == strings.HasPrefix(e.UserID, CLIENT_USER_ID_PREFIX) {
+		return false
+	}
+	if f.From.Valid && e.CreatedAt.Before(f.From.Time) {
+		return false
+	}
+	if f.To.Valid && !e.CreatedAt.Before(f.To.Time) {
+		return false
+	}
+	return true
+}
+
+func namespacePrefixMod(prefixes []string) qm.QueryMod {
+	return qm.And("namespace LIKE ANY(?::text[])", pq.Array(sqlutil.LikePrefixes(prefixes)))
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNull(s []string, v null.String) bool {
+	return v.Valid && contains(s, v.String)
+}
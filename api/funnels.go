@@ -0,0 +1,206 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+const (
+	MAX_FUNNEL_STEPS      = 10
+	DEFAULT_FUNNEL_WINDOW = 24 * time.Hour
+)
+
+var funnelGroupByColumns = map[string]bool{
+	models.EntryColumns.ClientFlowID:    true,
+	models.EntryColumns.ClientSessionID: true,
+	models.EntryColumns.UserID:          true,
+}
+
+// Replaces ? placeholders with positional ones starting at $offset.
+func rebind(clause string, offset int) string {
+	var b strings.Builder
+	for _, r := range clause {
+		if r == '?' {
+			fmt.Fprintf(&b, "$%d", offset)
+			offset++
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Builds the funnel query, selecting the number of groups reaching each step.
+//
+// Entries matching the filters are numbered into e, then step i keeps, per group, the
+// earliest entry matching the step after the entry of step i-1 and within the window
+// from the entry of the first step.
+func funnelQuery(r FunnelRequest, groupBy string, window time.Duration) (string, []interface{}, error) {
+	filterMods, err := r.ScanFilters.queryMods()
+	if err != nil {
+		return "", nil, err
+	}
+	eventTypes := make([]string, len(r.Steps))
+	for i, step := range r.Steps {
+		eventTypes[i] = step.ClientEventType
+	}
+	mods := []qm.QueryMod{qm.Select(
+		fmt.Sprintf("\"%s\" AS k", groupBy),
+		models.EntryColumns.ID,
+		models.EntryColumns.CreatedAt,
+		models.EntryColumns.ClientEventType,
+		models.EntryColumns.Data,
+	)}
+	mods = append(mods, filterMods...)
+	mods = append(mods,
+		qm.AndIn("client_event_type in ?", ToInterfaceSlice(eventTypes)...),
+		qm.And(fmt.Sprintf("\"%s\" IS NOT NULL", groupBy)))
+	base, args := queries.BuildQuery(models.Entries(mods...).Query)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "WITH e AS (%s)", strings.TrimSuffix(base, ";"))
+	for i, step := range r.Steps {
+		if step.ClientEventType == "" {
+			return "", nil, fmt.Errorf("expected client_event_type of step %d", i+1)
+		}
+		if len(step.DataFilters) > MAX_DATA_PREDICATES {
+			return "", nil, fmt.Errorf("expected at most %d data filters in step %d", MAX_DATA_PREDICATES, i+1)
+		}
+		conditions := []string{rebind("e.client_event_type = ?", len(args)+1)}
+		args = append(args, step.ClientEventType)
+		for _, p := range step.DataFilters {
+			clause, predicateArgs, err := p.condition()
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, rebind(clause, len(args)+1))
+			args = append(args, predicateArgs...)
+		}
+		where := strings.Join(conditions, " AND ")
+
+		if i == 0 {
+			fmt.Fprintf(&b, `,
+s0 AS (SELECT DISTINCT ON (e.k) e.k, e.created_at AS t0, e.created_at AS t, e.id FROM e
+  WHERE %s ORDER BY e.k, e.created_at, e.id)`, where)
+			continue
+		}
+		fmt.Fprintf(&b, `,
+s%d AS (SELECT DISTINCT ON (p.k) p.k, p.t0, e.created_at AS t, e.id FROM s%d AS p
+  JOIN e ON e.k = p.k AND (e.created_at, e.id) > (p.t, p.id) AND e.created_at <= p.t0 + $%d::float8 * interval '1 millisecond'
+  WHERE %s ORDER BY p.k, e.created_at, e.id)`, i, i-1, len(args)+1, where)
+		args = append(args, window.Milliseconds())
+	}
+
+	counts := make([]string, len(r.Steps))
+	for i := range r.Steps {
+		counts[i] = fmt.Sprintf("(SELECT count(*) FROM s%d)", i)
+	}
+	fmt.Fprintf(&b, "\nSELECT %s", strings.Join(counts, ", "))
+	return b.String(), args, nil
+}
+
+// FunnelsHandler counts groups of entries (flows, sessions or users) going through
+// an ordered list of steps. A group's funnel starts with its first entry of the first
+// step and only entries within the window from it count for later steps.
+// Like /aggregate, a bounded created_at range is required.
+func FunnelsHandler(c *gin.Context) {
+	r := FunnelRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	resp, err := handleFunnels(c, r)
+	concludeRequest(c, resp, err)
+}
+
+func handleFunnels(c *gin.Context, r FunnelRequest) (*FunnelResponse, *httputil.HttpError) {
+	if !r.From.Valid || !r.To.Valid {
+		return nil, httputil.NewBadRequestError(errors.New("expected both from and to to be set"))
+	}
+	if !r.From.Time.Before(r.To.Time) {
+		return nil, httputil.NewBadRequestError(errors.New("expected from to be before to"))
+	}
+	if r.To.Time.Sub(r.From.Time) > common.Config.AggregateMaxRange {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected range of at most %s", common.Config.AggregateMaxRange))
+	}
+	if len(r.Steps) < 2 || len(r.Steps) > MAX_FUNNEL_STEPS {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected between 2 and %d steps", MAX_FUNNEL_STEPS))
+	}
+	groupBy := models.EntryColumns.ClientFlowID
+	if r.GroupBy != "" {
+		groupBy = r.GroupBy
+	}
+	if !funnelGroupByColumns[groupBy] {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("unknown group_by %q, expected client_flow_id, client_session_id or user_id", groupBy))
+	}
+	window := DEFAULT_FUNNEL_WINDOW
+	if r.Window != "" {
+		var err error
+		if window, err = time.ParseDuration(r.Window); err != nil || window <= 0 {
+			return nil, httputil.NewBadRequestError(fmt.Errorf("invalid window %q", r.Window))
+		}
+	}
+
+	policy := middleware.GetPolicy(c)
+	if err := r.ScanFilters.restrict(policy); err != nil {
+		return nil, err
+	}
+	for _, step := range r.Steps {
+		if len(step.DataFilters) > 0 {
+			if err := checkData(policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	query, args, err := funnelQuery(r, groupBy, window)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+
+	db := c.MustGet("DB").(*sql.DB)
+	log := c.MustGet("LOGGER").(zerolog.Logger)
+	counts := make([]int64, len(r.Steps))
+	err = sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		timeout := common.Config.AggregateTimeout.Milliseconds()
+		if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)); err != nil {
+			return pkgerr.Wrap(err, "set statement_timeout")
+		}
+		ptrs := make([]interface{}, len(counts))
+		for i := range counts {
+			ptrs[i] = &counts[i]
+		}
+		return pkgerr.Wrap(tx.QueryRow(query, args...).Scan(ptrs...), "funnel query")
+	})
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+
+	resp := &FunnelResponse{Steps: make([]FunnelStepResult, len(r.Steps))}
+	for i, step := range r.Steps {
+		result := FunnelStepResult{ClientEventType: step.ClientEventType, Count: counts[i]}
+		if i > 0 {
+			result.DropOff = counts[i-1] - counts[i]
+		}
+		if counts[0] > 0 {
+			result.Conversion = float64(counts[i]) / float64(counts[0])
+		}
+		resp.Steps[i] = result
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+type FunnelsSuite struct {
+	suite.Suite
+}
+
+func TestFunnels(t *testing.T) {
+	suite.Run(t, new(FunnelsSuite))
+}
+
+func (suite *FunnelsSuite) TestRebind() {
+	suite.Equal("a = $3 AND b @> $4::jsonb", rebind("a = ? AND b @> ?::jsonb", 3))
+	suite.Equal("TRUE", rebind("TRUE", 1))
+}
+
+func (suite *FunnelsSuite) TestFunnelQuery() {
+	r := FunnelRequest{Steps: []FunnelStep{
+		{ClientEventType: "search"},
+		{ClientEventType: "click", DataFilters: []DataPredicate{{Path: "rank", Op: "lte", Value: json.RawMessage("3")}}},
+		{ClientEventType: "play"},
+	}}
+	r.Namespaces = []string{"archive"}
+	r.From = null.TimeFrom(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	r.To = null.TimeFrom(time.Date(2025, 3, 8, 0, 0, 0, 0, time.UTC))
+
+	query, args, err := funnelQuery(r, "client_flow_id", 30*time.Minute)
+	suite.Require().Nil(err)
+	suite.Contains(query, "WITH e AS (SELECT \"client_flow_id\" AS k")
+	suite.Contains(query, "\"client_flow_id\" IS NOT NULL")
+	suite.Contains(query, "s0 AS (SELECT DISTINCT ON (e.k)")
+	suite.Contains(query, "s2 AS (SELECT DISTINCT ON (p.k) p.k, p.t0, e.created_at AS t, e.id FROM s1 AS p")
+	suite.Contains(query, "SELECT (SELECT count(*) FROM s0), (SELECT count(*) FROM s1), (SELECT count(*) FROM s2)")
+
+	// Filters: namespace, from, to and the 3 event types.
+	suite.Equal("archive", args[0])
+	suite.Contains(query, "e.client_event_type = $7 ")
+	suite.Equal("search", args[6])
+	suite.Contains(query, "e.client_event_type = $8 AND (CASE WHEN jsonb_typeof(data #> $9::text[])")
+	suite.Equal("click", args[7])
+	suite.Equal("3", args[10])
+	suite.Equal(int64(30*60*1000), args[11])
+	suite.Equal("play", args[12])
+	suite.Equal(int64(30*60*1000), args[13])
+	suite.Len(args, 14)
+
+	r.Steps[1].DataFilters[0].Op = "unknown"
+	_, _, err = funnelQuery(r, "client_flow_id", time.Hour)
+	suite.NotNil(err)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -15,17 +14,23 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/segmentio/ksuid"
 	"github.com/volatiletech/null/v8"
-	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 
+	"github.com/Bnei-Baruch/chronicles/apikey"
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/ingest"
+	"github.com/Bnei-Baruch/chronicles/middleware"
 	"github.com/Bnei-Baruch/chronicles/models"
 	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
 	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/schema"
 )
 
 const (
-	DEFAULT_LIMIT         = 500
-	CLIENT_USER_ID_PREFIX = "client:"
+	DEFAULT_LIMIT            = 500
+	CLIENT_USER_ID_PREFIX    = common.CLIENT_USER_ID_PREFIX
+	INCOGNITO_USER_ID_PREFIX = common.INCOGNITO_USER_ID_PREFIX
 )
 
 func ToInterfaceSlice(s interface{}) []interface{} {
@@ -41,71 +46,122 @@ func ToInterfaceSlice(s interface{}) []interface{} {
 	return out
 }
 
+// ScanHandler pages through entries. Pass next_cursor or prev_cursor of the
+// response as cursor to get the following page in either direction. The raw
+// id and scan_back paging is kept for existing clients.
 func ScanHandler(c *gin.Context) {
 	r := ScanRequest{}
 	if c.Bind(&r) != nil {
 		return
 	}
 
-	db := c.MustGet("DB").(*sql.DB)
-	mods := []qm.QueryMod{}
-  if len(r.Fields) > 0 {
-    mods = append(mods, qm.Select(r.Fields...))
-  }
-  mods = append(mods, qm.Where("TRUE"))
-	if r.Id != "" {
-    if r.ScanBack.Valid && r.ScanBack.Bool {
-      mods = append(mods, qm.And("id <= ?", r.Id))
-    } else {
-      mods = append(mods, qm.And("id > ?", r.Id))
-    }
-	}
-	if len(r.Namespaces) > 0 {
-		mods = append(mods, qm.AndIn("namespace in ?", ToInterfaceSlice(r.Namespaces)...))
-	}
-	if len(r.UserIds) > 0 {
-		mods = append(mods, qm.AndIn("user_id in ?", ToInterfaceSlice(r.UserIds)...))
-	}
-	if len(r.EventTypes) > 0 {
-		mods = append(mods, qm.AndIn("client_event_type in ?", ToInterfaceSlice(r.EventTypes)...))
-	}
-	if r.Keycloak.Valid {
-		if r.Keycloak.Bool {
-			mods = append(mods, qm.And(fmt.Sprintf("user_id not like '%s%%'", CLIENT_USER_ID_PREFIX)))
+	orderBy := models.EntryColumns.ID
+	if r.OrderBy != "" {
+		orderBy = r.OrderBy
+	}
+	if orderBy != models.EntryColumns.ID && orderBy != models.EntryColumns.CreatedAt {
+		concludeRequest(c, nil, httputil.NewBadRequestError(errors.New("expected order_by to be either id or created_at")))
+		return
+	}
+	if r.Id != "" && orderBy != models.EntryColumns.ID {
+		concludeRequest(c, nil, httputil.NewBadRequestError(errors.New("paging by id requires order_by id")))
+		return
+	}
+	if r.Id != "" && r.Cursor != "" {
+		concludeRequest(c, nil, httputil.NewBadRequestError(errCursorWithRawId))
+		return
+	}
+
+	policy := middleware.GetPolicy(c)
+	if err := r.ScanFilters.restrict(policy); err != nil {
+		concludeRequest(c, nil, err)
+		return
+	}
+
+	digest, err := scanDigest(r.ScanFilters, orderBy)
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewInternalError(err))
+		return
+	}
+	secret := []byte(common.Config.CursorSecret)
+	var cursor *scanCursor
+	if r.Cursor != "" {
+		if cursor, err = decodeScanCursor(secret, r.Cursor, digest); err != nil {
+			concludeRequest(c, nil, httputil.NewBadRequestError(err))
+			return
+		}
+	}
+	scanBack := r.ScanBack.Valid && r.ScanBack.Bool
+	if cursor != nil {
+		scanBack = cursor.Back
+	}
+
+	p, err := parseFields(r.Fields)
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewBadRequestError(err))
+		return
+	}
+	// Cursors are built from the ordering columns.
+	p.require(models.EntryColumns.ID, orderBy)
+	mods, err := r.ScanFilters.queryMods()
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewBadRequestError(err))
+		return
+	}
+	if cursor != nil {
+		mods = append(mods, cursor.queryMod(orderBy))
+	} else if r.Id != "" {
+		if scanBack {
+			mods = append(mods, qm.And("id <= ?", r.Id))
 		} else {
-			mods = append(mods, qm.And(fmt.Sprintf("user_id like '%s%%'", CLIENT_USER_ID_PREFIX)))
+			mods = append(mods, qm.And("id > ?", r.Id))
 		}
 	}
 	limit := DEFAULT_LIMIT
 	if r.Limit != 0 {
 		limit = r.Limit
 	}
-  order_by := "id asc"
-  if r.ScanBack.Valid && r.ScanBack.Bool {
-    order_by = "id desc"
-  }
-	mods = append(mods, qm.OrderBy(order_by), qm.Limit(limit))
-	if entries, err := models.Entries(mods...).All(db); err != nil {
-		concludeRequest(c, nil, httputil.NewInternalError(err))
+	direction := "asc"
+	if scanBack {
+		direction = "desc"
+	}
+	if orderBy == models.EntryColumns.CreatedAt {
+		mods = append(mods, qm.OrderBy(fmt.Sprintf("created_at %s, id %s", direction, direction)))
 	} else {
-		if entries == nil {
-			entries = []*models.Entry{}
+		mods = append(mods, qm.OrderBy(fmt.Sprintf("id %s", direction)))
+	}
+	mods = append(mods, qm.Limit(limit))
+
+	db := c.MustGet("DB").(*sql.DB)
+	entries, err := p.queryEntries(db, mods...)
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewInternalError(err))
+		return
+	}
+	maskScanEntries(policy, entries)
+
+	resp := ScanResponse{Entries: entries}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1].Entry
+		first := entries[0].Entry
+		if resp.NextCursor, err = encodeScanCursor(secret, last, scanBack, orderBy, digest); err != nil {
+			concludeRequest(c, nil, httputil.NewInternalError(err))
+			return
+		}
+		if resp.PrevCursor, err = encodeScanCursor(secret, first, !scanBack, orderBy, digest); err != nil {
+			concludeRequest(c, nil, httputil.NewInternalError(err))
+			return
 		}
-		concludeRequest(c, ScanResponse{entries}, nil)
+	} else {
+		// Nothing new yet, continue from the same position later.
+		resp.NextCursor = r.Cursor
 	}
+	concludeRequest(c, resp, nil)
 }
 
 func HealthCheckHandler(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
-	defer cancel()
-
 	db := c.MustGet("DB").(*sql.DB)
-	err := db.PingContext(ctx)
-	if err == nil {
-		err = ctx.Err()
-	}
-
-	if err != nil {
+	if err := sqlutil.Ping(db, time.Second); err != nil {
 		c.AbortWithError(http.StatusFailedDependency, pkgerr.Wrap(err, "DB ping")).SetType(gin.ErrorTypePublic)
 		return
 	}
@@ -114,63 +170,305 @@ func HealthCheckHandler(c *gin.Context) {
 }
 
 func AppendsHandler(c *gin.Context) {
+	body, ok := rawBody(c)
+	if !ok {
+		return
+	}
 	r := AppendsRequest{}
-	if c.Bind(&r) != nil {
+	if err := c.Bind(&r); err != nil {
+		rejectBody(c, rejected.ENDPOINT_APPENDS, null.Int{}, body, AppendRequest{}, err)
 		return
 	}
 
 	resp, err := handleAppends(c, r)
+	if resp != nil {
+		rejectAppends(c, body, r, resp)
+	}
 	concludeRequest(c, resp, err)
 }
 
 func handleAppends(c *gin.Context, r AppendsRequest) (*AppendsResponse, *httputil.HttpError) {
 	now := time.Now()
-	var resp AppendsResponse
-	for _, appendOffsetRequest := range r.AppendRequests {
+	resp := AppendsResponse{
+		Ids:     make([]string, len(r.AppendRequests)),
+		Results: make([]AppendResult, len(r.AppendRequests)),
+	}
+
+	// Validate the whole batch up front, only valid entries are written.
+	entries := []*models.Entry{}
+	indexes := []int{}
+	for i, appendOffsetRequest := range r.AppendRequests {
+		if err := validateAppend(appendOffsetRequest.Append); err != nil {
+			resp.Results[i] = AppendResult{Accepted: false, Error: err.Error(), invalid: true}
+			continue
+		}
+		if err := authorizeAppend(c, appendOffsetRequest.Append); err != nil {
+			resp.Results[i] = AppendResult{Accepted: false, Error: err.Error()}
+			continue
+		}
+		if err := authorizeKey(c, appendOffsetRequest.Append); err != nil {
+			resp.Results[i] = AppendResult{Accepted: false, Error: err.Error()}
+			continue
+		}
+		if err := validateSchema(c, appendOffsetRequest.Append); err != nil {
+			if err.Code == http.StatusInternalServerError {
+				return nil, err
+			}
+			resp.Results[i] = AppendResult{Accepted: false, Error: err.Error(), invalid: true}
+			var violation *schema.ViolationError
+			if errors.As(err.Err, &violation) {
+				resp.Results[i].Pointer = &violation.Pointer
+				resp.Results[i].SchemaVersion = violation.Version
+			}
+			continue
+		}
 		then := now.Add(time.Duration(appendOffsetRequest.Offset) * time.Millisecond)
-		if appendResponse, err := handleAppend(c, then, appendOffsetRequest.Append); err != nil {
+		entry := newEntry(c, then, appendOffsetRequest.Append)
+		entries = append(entries, entry)
+		indexes = append(indexes, i)
+	}
+
+	if len(entries) > 0 {
+		dropped, retryAfter := limitEntries(c, entries)
+		allowed, allowedIndexes := entries[:0], indexes[:0]
+		for j, entry := range entries {
+			if dropped[j] {
+				resp.Results[indexes[j]] = AppendResult{Accepted: false, Error: middleware.ErrRateLimited.Error()}
+				continue
+			}
+			allowed = append(allowed, entry)
+			allowedIndexes = append(allowedIndexes, indexes[j])
+		}
+		if len(allowed) < len(entries) {
+			c.Header("Retry-After", httputil.RetryAfter(retryAfter))
+			if len(allowed) == 0 {
+				return nil, httputil.NewTooManyRequestsError(middleware.ErrRateLimited)
+			}
+		}
+		entries, indexes = allowed, allowedIndexes
+	}
+
+	if len(entries) > 0 {
+		if err := writeCharged(c, now, entries); err != nil {
 			return nil, err
-		} else {
-			resp.Ids = append(resp.Ids, appendResponse.Id)
 		}
 	}
+
+	// Ids might have been replaced by previously stored duplicates.
+	for j, entry := range entries {
+		resp.Ids[indexes[j]] = entry.ID
+		resp.Results[indexes[j]] = AppendResult{Id: entry.ID, Accepted: true}
+	}
+
 	return &resp, nil
 }
 
 func AppendHandler(c *gin.Context) {
+	body, ok := rawBody(c)
+	if !ok {
+		return
+	}
 	r := AppendRequest{}
-	if c.Bind(&r) != nil {
+	if err := c.Bind(&r); err != nil {
+		rejectBody(c, rejected.ENDPOINT_APPEND, null.Int{}, body, r, err)
 		return
 	}
 
 	resp, err := handleAppend(c, time.Now(), r)
+	// Bad requests are the ones failing validation.
+	if err != nil && err.Code == http.StatusBadRequest {
+		rejectBody(c, rejected.ENDPOINT_APPEND, null.Int{}, body, r, err.Err)
+	}
 	concludeRequest(c, resp, err)
 }
 
 func handleAppend(c *gin.Context, now time.Time, r AppendRequest) (*AppendResponse, *httputil.HttpError) {
+	if err := validateAppend(r); err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	if err := authorizeAppend(c, r); err != nil {
+		return nil, err
+	}
+	if err := authorizeKey(c, r); err != nil {
+		return nil, err
+	}
+	if err := validateSchema(c, r); err != nil {
+		return nil, err
+	}
+
+	entry := newEntry(c, now, r)
+	if dropped, retryAfter := limitEntries(c, []*models.Entry{entry}); dropped[0] {
+		c.Header("Retry-After", httputil.RetryAfter(retryAfter))
+		return nil, httputil.NewTooManyRequestsError(middleware.ErrRateLimited)
+	}
+	if err := writeCharged(c, now, []*models.Entry{entry}); err != nil {
+		return nil, err
+	}
+
+	return &AppendResponse{entry.ID}, nil
+}
+
+// Writes entries charged to the API key's quota, refunding them when the write fails.
+// Entries are charged after the write when the quota couldn't be checked.
+func writeCharged(c *gin.Context, now time.Time, entries []*models.Entry) *httputil.HttpError {
+	charged, err := consumeQuota(c, now, len(entries))
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(c, entries); err != nil {
+		if charged {
+			refundQuota(c, now, len(entries))
+		}
+		return err
+	}
+	if !charged {
+		chargeQuota(c, now, len(entries))
+	}
+	return nil
+}
+
+func writeEntries(c *gin.Context, entries []*models.Entry) *httputil.HttpError {
+	writer := c.MustGet("INGEST").(ingest.Writer)
+	log := c.MustGet("LOGGER").(zerolog.Logger)
+	if err := writer.Write(log, entries); err != nil {
+		if errors.Is(err, ingest.ErrQueueFull) || errors.Is(err, ingest.ErrClosed) {
+			c.Header("Retry-After", "1")
+			return httputil.NewServiceUnavailableError(err)
+		}
+		return httputil.NewInternalError(err)
+	}
+	return nil
+}
+
+// Appends on behalf of keycloak users require their token, unless auth is skipped.
+func authorizeAppend(c *gin.Context, r AppendRequest) *httputil.HttpError {
+	keycloakID := valueOrEmpty(r.KeycloakId)
+	if keycloakID == "" || common.Config.SkipAuth {
+		return nil
+	}
+	claims := middleware.GetClaims(c)
+	if claims == nil {
+		return httputil.NewUnauthorizedError(errors.New("expected an Authorization header for keycloak_id"))
+	}
+	if claims.Subject != keycloakID {
+		return httputil.NewForbiddenError(errors.New("expected keycloak_id to be the token subject"))
+	}
+	return nil
+}
+
+// Appends with an API key must be in its scope.
+func authorizeKey(c *gin.Context, r AppendRequest) *httputil.HttpError {
+	key := middleware.GetAPIKey(c)
+	if key == nil || key.Allows(r.Namespace, r.ClientEventType) {
+		return nil
+	}
+	return httputil.NewForbiddenError(fmt.Errorf("API key %s does not allow %s events in namespace %s", key.ID, r.ClientEventType, r.Namespace))
+}
+
+// Validates data against the latest schema of the event type. Violations of schemas
+// in warn mode are only logged.
+func validateSchema(c *gin.Context, r AppendRequest) *httputil.HttpError {
+	schemas := middleware.GetSchemas(c)
+	if schemas == nil {
+		return nil
+	}
+	err := schemas.Validate(r.Namespace, r.ClientEventType, r.Data.JSON)
+	var violation *schema.ViolationError
+	if errors.As(err, &violation) {
+		if violation.Mode == schema.MODE_ENFORCE {
+			return httputil.NewBadRequestError(violation)
+		}
+		log := c.MustGet("LOGGER").(zerolog.Logger)
+		log.Warn().Err(violation).Msg("Schema violation")
+		return nil
+	}
+	if err != nil {
+		return httputil.NewInternalError(err)
+	}
+	return nil
+}
+
+// Counts n appended entries against the daily quota of the API key. Returns whether these
+// were counted, entries are allowed uncounted when the quota fails with transient errors.
+func consumeQuota(c *gin.Context, now time.Time, n int) (bool, *httputil.HttpError) {
+	key := middleware.GetAPIKey(c)
+	if key == nil {
+		return true, nil
+	}
+	db := c.MustGet("DB").(*sql.DB)
+	if err := apikey.Consume(db, key, now, n); err != nil {
+		if errors.Is(err, apikey.ErrQuotaExceeded) {
+			tomorrow := apikey.Day(now).AddDate(0, 0, 1)
+			c.Header("Retry-After", httputil.RetryAfter(tomorrow.Sub(now)))
+			return false, httputil.NewTooManyRequestsError(err)
+		}
+		if sqlutil.IsTransient(err) {
+			log := c.MustGet("LOGGER").(zerolog.Logger)
+			log.Warn().Err(err).Str("key_id", key.ID).Int("events", n).Msg("API key quota unavailable, charging after the write")
+			return false, nil
+		}
+		return false, httputil.NewInternalError(err)
+	}
+	return true, nil
+}
+
+// Counts n written entries, allowed without checking the quota, against the quota of the API key.
+func chargeQuota(c *gin.Context, now time.Time, n int) {
+	key := middleware.GetAPIKey(c)
+	if key == nil {
+		return
+	}
+	db := c.MustGet("DB").(*sql.DB)
+	if err := apikey.Charge(db, key, now, n); err != nil {
+		log := c.MustGet("LOGGER").(zerolog.Logger)
+		log.Error().Err(err).Str("key_id", key.ID).Int("events", n).Msg("Charge API key quota")
+	}
+}
+
+// Uncounts n entries that failed to be written from the daily quota of the API key.
+func refundQuota(c *gin.Context, now time.Time, n int) {
+	key := middleware.GetAPIKey(c)
+	if key == nil {
+		return
+	}
+	db := c.MustGet("DB").(*sql.DB)
+	if err := apikey.Refund(db, key, now, n); err != nil {
+		log := c.MustGet("LOGGER").(zerolog.Logger)
+		log.Error().Err(err).Str("key_id", key.ID).Int("events", n).Msg("Refund API key quota")
+	}
+}
+
+func validateAppend(r AppendRequest) error {
 	if valueOrEmpty(r.KeycloakId) == "" && valueOrEmpty(r.ClientId) == "" {
-		return nil, httputil.NewBadRequestError(errors.New("expected either keycloak_id or client_id to be set"))
+		return errors.New("expected either keycloak_id or client_id to be set")
 	}
 	if valueOrEmpty(r.KeycloakId) != "" && valueOrEmpty(r.ClientId) != "" {
-		return nil, httputil.NewBadRequestError(errors.New("expected only one of keycloak_id or client_id to be set"))
+		return errors.New("expected only one of keycloak_id or client_id to be set")
 	}
 	if r.Namespace == "" {
-		return nil, httputil.NewBadRequestError(errors.New("expected namespace to not be empty"))
+		return errors.New("expected namespace to not be empty")
 	}
 	if r.ClientEventType == "" {
-		return nil, httputil.NewBadRequestError(errors.New("expected client_event_type to not be empty"))
+		return errors.New("expected client_event_type to not be empty")
 	}
 	if r.Data.Valid {
 		if _, err := json.Marshal(r.Data); err != nil {
-			return nil, httputil.NewBadRequestError(errors.New("expected data to be a valid json"))
+			return errors.New("expected data to be a valid json")
 		}
 	}
+	return nil
+}
 
-	entry := models.Entry{
-		ID:              ksuid.New().String(),
+func newEntry(c *gin.Context, now time.Time, r AppendRequest) *models.Entry {
+	return buildEntry(ksuid.New().String(), now, c.ClientIP(), c.Request.UserAgent(), r)
+}
+
+func buildEntry(id string, now time.Time, ipAddr, userAgent string, r AppendRequest) *models.Entry {
+	entry := &models.Entry{
+		ID:              id,
 		CreatedAt:       now,
-		IPAddr:          c.ClientIP(),
-		UserAgent:       c.Request.UserAgent(),
+		IPAddr:          ipAddr,
+		UserAgent:       userAgent,
 		Namespace:       r.Namespace,
 		ClientEventID:   r.ClientEventID,
 		ClientEventType: r.ClientEventType,
@@ -185,17 +483,9 @@ func handleAppend(c *gin.Context, now time.Time, r AppendRequest) (*AppendRespon
 	} else {
 		entry.UserID = fmt.Sprintf("%s%s", CLIENT_USER_ID_PREFIX, valueOrEmpty(r.ClientId))
 	}
+	ingest.Enrich(entry)
 
-	db := c.MustGet("DB").(*sql.DB)
-	log := c.MustGet("LOGGER").(zerolog.Logger)
-	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
-		return entry.Insert(tx, boil.Infer())
-	})
-	if err != nil {
-		return nil, httputil.NewInternalError(err)
-	}
-
-	return &AppendResponse{entry.ID}, nil
+	return entry
 }
 
 func valueOrEmpty(s null.String) string {
@@ -1,9 +1,16 @@
 package api
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
 )
 
 type HandlersSuite struct {
@@ -19,3 +26,44 @@ func TestHandlers(t *testing.T) {
 func (suite *HandlersSuite) TestHandleSearch() {
 	suite.Nil(nil)
 }
+
+func (suite *HandlersSuite) TestValidateAppend() {
+	valid := AppendRequest{
+		ClientId:        null.StringFrom("local:1"),
+		Namespace:       "archive",
+		ClientEventType: "search",
+	}
+	suite.Nil(validateAppend(valid))
+
+	both := valid
+	both.KeycloakId = null.StringFrom("kc")
+	suite.EqualError(validateAppend(both), "expected only one of keycloak_id or client_id to be set")
+
+	noNamespace := valid
+	noNamespace.Namespace = ""
+	suite.EqualError(validateAppend(noNamespace), "expected namespace to not be empty")
+}
+
+func (suite *HandlersSuite) TestAuthorizeAppend() {
+	common.Init()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	anonymous := AppendRequest{ClientId: null.StringFrom("local:1")}
+	suite.Nil(authorizeAppend(c, anonymous))
+
+	keycloak := AppendRequest{KeycloakId: null.StringFrom("user-1")}
+	suite.Equal(http.StatusUnauthorized, authorizeAppend(c, keycloak).Code)
+
+	claims := &middleware.Claims{}
+	claims.Subject = "user-2"
+	c.Set("CLAIMS", claims)
+	suite.Equal(http.StatusForbidden, authorizeAppend(c, keycloak).Code)
+
+	claims.Subject = "user-1"
+	suite.Nil(authorizeAppend(c, keycloak))
+
+	common.Config.SkipAuth = true
+	defer func() { common.Config.SkipAuth = false }()
+	claims.Subject = "user-2"
+	suite.Nil(authorizeAppend(c, keycloak))
+}
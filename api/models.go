@@ -1,30 +1,96 @@
 package api
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/volatiletech/null/v8"
 
 	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+	"github.com/Bnei-Baruch/chronicles/rejected"
 )
 
+type ScanFilters struct {
+	EventTypes []string  `json:"event_types,omitempty"`
+	UserIds    []string  `json:"user_ids,omitempty"`
+	Namespaces []string  `json:"namespaces,omitempty"`
+	Keycloak   null.Bool `json:"keycloak,omitempty"`
+
+	ClientEventIds   []string `json:"client_event_ids,omitempty"`
+	ClientFlowIds    []string `json:"client_flow_ids,omitempty"`
+	ClientFlowTypes  []string `json:"client_flow_types,omitempty"`
+	ClientSessionIds []string `json:"client_session_ids,omitempty"`
+
+	// Range of created_at, from is inclusive and to is exclusive.
+	From null.Time `json:"from,omitempty"`
+	To   null.Time `json:"to,omitempty"`
+
+	// All predicates on the data column must match.
+	DataFilters []DataPredicate `json:"data_filters,omitempty"`
+
+	// Namespace prefixes the role reads, set by restrict.
+	namespacePrefixes []string
+}
+
+// Predicate on a path in the data JSONB column.
+type DataPredicate struct {
+	// Dot separated path, e.g., "video.position". Empty path is the whole data for "contains".
+	Path string `json:"path"`
+	// One of eq, ne, exists, contains, gt, gte, lt, lte.
+	Op string `json:"op"`
+	// JSON value to compare with, numbers for gt, gte, lt, lte. Not used by exists.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
 type ScanRequest struct {
 	Id    string `json:"id,omitempty"`
 	Limit int    `json:"limit,omitempty"`
 
 	// Filters.
-	EventTypes []string  `json:"event_types,omitempty"`
-	UserIds    []string  `json:"user_ids,omitempty"`
-	Namespaces []string  `json:"namespaces,omitempty"`
-	Keycloak   null.Bool `json:"keycloak,omitempty"`
+	ScanFilters
+
+	// Empty will bring all fields. Entries columns or data sub-paths, e.g., "data.query".
+	Fields []string `json:"fields,omitempty"`
 
-  // Empty will bring all fields.
-  Fields []string  `json:"fields,omitempty"`
+	// If true, will scan back.
+	ScanBack null.Bool `json:"scan_back,omitempty"`
 
-  // If true, will scan back.
-  ScanBack null.Bool `json:"scan_back,omitempty"`
+	// Either "id" (default) or "created_at".
+	// Paging with id is only supported when ordering by id.
+	OrderBy string `json:"order_by,omitempty"`
+
+	// Opaque cursor from a previous response, replaces id and scan_back.
+	// Must be used with the same filters and order it was issued for.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type ExportRequest struct {
+	// Resume after this id.
+	Id string `json:"id,omitempty"`
+	// Zero exports all matching entries.
+	Limit int `json:"limit,omitempty"`
+
+	// Filters.
+	ScanFilters
+
+	// Empty will bring all fields. Entries columns or data sub-paths, e.g., "data.query".
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Entry along with projected data sub-paths, keyed by field name.
+type ScanEntry struct {
+	*models.Entry
+	Paths map[string]null.JSON
 }
 
 type ScanResponse struct {
-	Entries []*models.Entry `json:"entries"`
+	Entries []*ScanEntry `json:"entries"`
+
+	// Continues in the same direction after the last entry.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Continues in the opposite direction before the first entry.
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 type AppendRequest struct {
@@ -52,6 +118,249 @@ type AppendsRequest struct {
 	AppendRequests []AppendOffsetRequest `json:"append_requests"`
 }
 
+type AppendResult struct {
+	Id       string `json:"id,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+	// JSON pointer of the value in data violating the event type's schema.
+	Pointer       *string `json:"pointer,omitempty"`
+	SchemaVersion int     `json:"schema_version,omitempty"`
+
+	// Rejected by validation, stored in rejected_entries.
+	invalid bool
+}
+
 type AppendsResponse struct {
-	Ids []string `json:"ids"`
+	// Ids are aligned with the requests, empty for rejected ones.
+	Ids     []string       `json:"ids"`
+	Results []AppendResult `json:"results"`
+}
+
+type VisitorsRequest struct {
+	// Dates range, from is inclusive and to is exclusive.
+	From time.Time `form:"from" time_format:"2006-01-02" time_utc:"1" binding:"required"`
+	To   time.Time `form:"to" time_format:"2006-01-02" time_utc:"1" binding:"required"`
+
+	// Empty will bring all namespaces.
+	Namespaces []string `form:"namespaces"`
+	// Any of day, month, year. Empty will bring all.
+	Granularities []string `form:"granularity"`
+
+	// Namespace prefixes the role reads, set by the handler.
+	namespacePrefixes []string
+}
+
+type VisitorsPeriod struct {
+	Namespace   string `json:"namespace"`
+	Granularity string `json:"granularity"`
+	Period      string `json:"period"`
+	Visitors    int64  `json:"visitors"`
+	Incognito   int64  `json:"incognito"`
+	LoggedIn    int64  `json:"logged_in"`
+}
+
+type VisitorsResponse struct {
+	Visitors []VisitorsPeriod `json:"visitors"`
+	// Either "entries" or "rollups", counts computed from rollups are estimates.
+	Source string `json:"source"`
+}
+
+type AggregateMetric struct {
+	// One of count, count_distinct, min, max, sum, avg.
+	Op string `json:"op"`
+	// Column for count_distinct (user_id, client_session_id, client_flow_id)
+	// or numeric data path for min, max, sum and avg, e.g., "data.position".
+	Field string `json:"field,omitempty"`
+}
+
+type AggregateRequest struct {
+	// Filters, from and to are required.
+	ScanFilters
+
+	// Any of namespace, client_event_type, client_flow_type or data paths, e.g., "data.query".
+	GroupBy []string `json:"group_by,omitempty"`
+	// Groups also by time (UTC), one of hour, day, week, month, year.
+	TimeBucket string            `json:"time_bucket,omitempty"`
+	Metrics    []AggregateMetric `json:"metrics"`
+
+	// Max number of groups.
+	Limit int `json:"limit,omitempty"`
+}
+
+type AggregateRow struct {
+	// Group by dimension, time included as "time".
+	Group map[string]interface{} `json:"group"`
+	// By metric name, e.g., "count" or "count_distinct(user_id)".
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+type AggregateResponse struct {
+	Rows []AggregateRow `json:"rows"`
+	// Whether there were more groups than the limit.
+	Truncated bool `json:"truncated"`
+	// Either "entries" or "rollups", distinct counts computed from rollups are estimates.
+	Source string `json:"source"`
+}
+
+type FunnelStep struct {
+	ClientEventType string `json:"client_event_type"`
+	// Optional predicates the step entries must match.
+	DataFilters []DataPredicate `json:"data_filters,omitempty"`
+}
+
+type FunnelRequest struct {
+	// Filters, from and to are required.
+	ScanFilters
+
+	// Ordered steps, at least two.
+	Steps []FunnelStep `json:"steps"`
+	// One of client_flow_id (default), client_session_id or user_id.
+	GroupBy string `json:"group_by,omitempty"`
+	// Max duration from the first step to the last, e.g., "30m". Defaults to 24h.
+	Window string `json:"window,omitempty"`
+}
+
+type FunnelStepResult struct {
+	ClientEventType string `json:"client_event_type"`
+	// Number of groups reaching the step.
+	Count int64 `json:"count"`
+	// Groups reaching the previous step but not this one.
+	DropOff int64 `json:"drop_off"`
+	// Ratio of groups reaching the step out of those reaching the first one.
+	Conversion float64 `json:"conversion"`
+}
+
+type FunnelResponse struct {
+	Steps []FunnelStepResult `json:"steps"`
+}
+
+type RetentionRequest struct {
+	Namespace string `form:"namespace" binding:"required"`
+	// Cohorts of users first seen in dates range, from is inclusive and to is exclusive.
+	From time.Time `form:"from" time_format:"2006-01-02" time_utc:"1" binding:"required"`
+	To   time.Time `form:"to" time_format:"2006-01-02" time_utc:"1" binding:"required"`
+	// Either day or week (default).
+	Period string `form:"period"`
+	// Number of periods following the cohort period, default 8.
+	Periods int `form:"periods"`
+}
+
+type RetentionCohort struct {
+	// Start of the period (UTC) users were first seen in.
+	Cohort string `json:"cohort"`
+	// Keycloak users or client ids.
+	Keycloak bool  `json:"keycloak"`
+	Users    int64 `json:"users"`
+	// Users active in the cohort period and in each of the following periods.
+	Active []int64 `json:"active"`
+	// Active out of users.
+	Retention []float64 `json:"retention"`
+}
+
+type RetentionResponse struct {
+	Cohorts []RetentionCohort `json:"cohorts"`
+	// Users first seen later are not in cohorts yet.
+	CoveredUntil time.Time `json:"covered_until"`
+}
+
+type TimelineRequest struct {
+	// Range of created_at (RFC3339), defaults to the week before to, which defaults to now.
+	From time.Time `form:"from"`
+	To   time.Time `form:"to"`
+	// Empty will bring all namespaces.
+	Namespaces []string `form:"namespaces"`
+	// Inactivity gap ending sessions of entries without client_session_id, e.g., "30m".
+	Gap string `form:"gap"`
+	// Max number of entries, the most recent ones are returned.
+	Limit int `form:"limit"`
+}
+
+type TimelineSession struct {
+	ClientSessionId null.String `json:"client_session_id"`
+	// Entries have no client_session_id, the session was inferred by inactivity gaps.
+	Inferred bool      `json:"inferred"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	// Seconds from the first entry to the last.
+	Duration float64 `json:"duration"`
+	// By client_event_type.
+	EventCounts map[string]int  `json:"event_counts"`
+	Entries     []*models.Entry `json:"entries"`
+}
+
+type TimelineResponse struct {
+	// Ordered by start.
+	Sessions []*TimelineSession `json:"sessions"`
+	// Whether there were more entries than the limit.
+	Truncated bool `json:"truncated"`
+}
+
+type EntriesRequest struct {
+	Ids            []string `form:"id" json:"ids,omitempty"`
+	ClientEventIds []string `form:"client_event_id" json:"client_event_ids,omitempty"`
+	// Limits the client_event_id lookup to a namespace.
+	Namespace string `form:"namespace" json:"namespace,omitempty"`
+
+	// Empty will bring all fields. Entries columns or data sub-paths, e.g., "data.query".
+	Fields []string `form:"fields" json:"fields,omitempty"`
+}
+
+type EntriesResponse struct {
+	// Ordered by id.
+	Entries []*ScanEntry `json:"entries"`
+	// Requested ids and client_event_ids not found.
+	Missing []string `json:"missing"`
+}
+
+// Filters of /tail, as of ScanFilters, in query parameters.
+type TailRequest struct {
+	EventTypes []string `form:"event_types"`
+	UserIds    []string `form:"user_ids"`
+	Namespaces []string `form:"namespaces"`
+	Keycloak   *bool    `form:"keycloak"`
+
+	ClientFlowIds    []string `form:"client_flow_ids"`
+	ClientFlowTypes  []string `form:"client_flow_types"`
+	ClientSessionIds []string `form:"client_session_ids"`
+}
+
+type RateLimitsResponse struct {
+	// Events dropped per key, e.g., "user:abc", since the server started.
+	Since   time.Time           `json:"since"`
+	Dropped []ratelimit.Dropped `json:"dropped"`
+}
+
+type SchemasRequest struct {
+	Namespace string `form:"namespace"`
+}
+
+type RegisterSchemaRequest struct {
+	Namespace       string          `json:"namespace" binding:"required"`
+	ClientEventType string          `json:"client_event_type" binding:"required"`
+	Schema          json.RawMessage `json:"schema" binding:"required"`
+	// enforce (default), warn or off.
+	Mode string `json:"mode"`
+}
+
+type SchemaModeRequest struct {
+	Mode string `json:"mode" binding:"required"`
+}
+
+// Filters of /rejected, in query parameters.
+type RejectedRequest struct {
+	Namespaces       []string `form:"namespaces"`
+	ClientEventTypes []string `form:"client_event_types"`
+	// Range of created_at (RFC3339).
+	From     time.Time `form:"from"`
+	To       time.Time `form:"to"`
+	Replayed *bool     `form:"replayed"`
+	// Next of the previous page.
+	After string `form:"after"`
+	Limit int    `form:"limit"`
+}
+
+type RejectedResponse struct {
+	Entries []*rejected.Entry `json:"entries"`
+	// Set when there might be more entries, pass it as after.
+	Next string `json:"next,omitempty"`
 }
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+const MAX_DATA_PREDICATES = 20
+
+var dataPathSegmentRe = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// Parses a dot separated path into the data JSONB column, e.g., "video.position".
+func parseDataPath(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if !dataPathSegmentRe.MatchString(segment) {
+			return nil, fmt.Errorf("invalid data path %q", path)
+		}
+	}
+	return segments, nil
+}
+
+// Nests value under path, i.e., ["a", "b"] and 1 gives {"a": {"b": 1}}.
+func nestUnderPath(path []string, value json.RawMessage) (string, error) {
+	var nested interface{} = value
+	for i := len(path) - 1; i >= 0; i-- {
+		nested = map[string]interface{}{path[i]: nested}
+	}
+	b, err := json.Marshal(nested)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func parseNumber(value json.RawMessage) (json.Number, bool) {
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return "", false
+	}
+	number, ok := v.(json.Number)
+	return number, ok
+}
+
+// Compiles the predicate into a parameterized condition on the data column.
+// Equality and containment are expressed with @> so they can use the GIN index on data.
+// Note sqlboiler treats every ? as a placeholder, so jsonb ? operators can't be used here.
+func (p DataPredicate) queryMod() (qm.QueryMod, error) {
+	clause, args, err := p.condition()
+	if err != nil {
+		return nil, err
+	}
+	return qm.And(clause, args...), nil
+}
+
+// Returns the condition with ? placeholders and its arguments.
+func (p DataPredicate) condition() (string, []interface{}, error) {
+	path, err := parseDataPath(p.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	needsPath := p.Op != "contains"
+	if needsPath && len(path) == 0 {
+		return "", nil, fmt.Errorf("expected path for data predicate %q", p.Op)
+	}
+	needsValue := p.Op != "exists"
+	if needsValue && len(p.Value) == 0 {
+		return "", nil, fmt.Errorf("expected value for data predicate %q on %q", p.Op, p.Path)
+	}
+
+	switch p.Op {
+	case "exists":
+		return "data #> ?::text[] IS NOT NULL", []interface{}{pq.Array(path)}, nil
+	case "eq":
+		nested, err := nestUnderPath(path, p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		// Containment alone would also match arrays containing value.
+		return "(data @> ?::jsonb AND data #> ?::text[] = ?::jsonb)", []interface{}{nested, pq.Array(path), string(p.Value)}, nil
+	case "ne":
+		return "data #> ?::text[] IS DISTINCT FROM ?::jsonb", []interface{}{pq.Array(path), string(p.Value)}, nil
+	case "contains":
+		nested, err := nestUnderPath(path, p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "data @> ?::jsonb", []interface{}{nested}, nil
+	case "gt", "gte", "lt", "lte":
+		number, ok := parseNumber(p.Value)
+		if !ok {
+			return "", nil, fmt.Errorf("expected numeric value for data predicate %q on %q", p.Op, p.Path)
+		}
+		operator := map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[p.Op]
+		// Non numeric values never match instead of failing the cast.
+		clause := fmt.Sprintf(
+			"(CASE WHEN jsonb_typeof(data #> ?::text[]) = 'number' THEN (data #>> ?::text[])::numeric END) %s ?::numeric", operator)
+		return clause, []interface{}{pq.Array(path), pq.Array(path), number.String()}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown data predicate op %q, expected one of eq, ne, exists, contains, gt, gte, lt, lte", p.Op)
+	}
+}
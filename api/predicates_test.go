@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type PredicatesSuite struct {
+	suite.Suite
+}
+
+func TestPredicates(t *testing.T) {
+	suite.Run(t, new(PredicatesSuite))
+}
+
+func (suite *PredicatesSuite) buildWhere(p DataPredicate) (string, []interface{}) {
+	mod, err := p.queryMod()
+	suite.Require().Nil(err)
+	return queries.BuildQuery(models.Entries(mod).Query)
+}
+
+func (suite *PredicatesSuite) TestEq() {
+	sql, args := suite.buildWhere(DataPredicate{Path: "search.query", Op: "eq", Value: json.RawMessage(`"kabbalah"`)})
+	suite.Contains(sql, "(data @> $1::jsonb AND data #> $2::text[] = $3::jsonb)")
+	suite.Equal(`{"search":{"query":"kabbalah"}}`, args[0])
+	suite.Equal(`"kabbalah"`, args[2])
+}
+
+func (suite *PredicatesSuite) TestNumeric() {
+	sql, args := suite.buildWhere(DataPredicate{Path: "position", Op: "gte", Value: json.RawMessage(`12.5`)})
+	suite.Contains(sql, "THEN (data #>> $2::text[])::numeric END) >= $3::numeric")
+	suite.Equal("12.5", args[2])
+
+	_, err := DataPredicate{Path: "position", Op: "gt", Value: json.RawMessage(`"12"`)}.queryMod()
+	suite.NotNil(err)
+}
+
+func (suite *PredicatesSuite) TestInvalid() {
+	_, err := DataPredicate{Path: "a'; drop table entries; --", Op: "exists"}.queryMod()
+	suite.EqualError(err, `invalid data path "a'; drop table entries; --"`)
+
+	_, err = DataPredicate{Path: "a", Op: "like", Value: json.RawMessage(`1`)}.queryMod()
+	suite.NotNil(err)
+
+	_, err = DataPredicate{Op: "exists"}.queryMod()
+	suite.NotNil(err)
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+const DATA_PATH_PREFIX = "data."
+
+var (
+	entryType    = reflect.TypeOf(models.Entry{})
+	entryMapping = queries.MakeStructMapping(entryType)
+	entryColumns = columnsOf(models.EntryColumns)
+)
+
+func columnsOf(columns interface{}) map[string]bool {
+	v := reflect.ValueOf(columns)
+	m := make(map[string]bool, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		m[v.Field(i).String()] = true
+	}
+	return m
+}
+
+// Projection of entries columns and data sub-paths such as "data.query".
+type projection struct {
+	columns []string
+	paths   []projectedPath
+}
+
+type projectedPath struct {
+	name string
+	path []string
+}
+
+// Parses "data.a.b" into the data path ["a", "b"].
+func parseProjectedDataPath(field string) ([]string, bool) {
+	if !strings.HasPrefix(field, DATA_PATH_PREFIX) {
+		return nil, false
+	}
+	path, err := parseDataPath(strings.TrimPrefix(field, DATA_PATH_PREFIX))
+	if err != nil || len(path) == 0 {
+		return nil, false
+	}
+	return path, true
+}
+
+// Validates the requested fields. Empty fields project all columns.
+func parseFields(fields []string) (*projection, error) {
+	p := &projection{}
+	unknown := []string{}
+	for _, field := range fields {
+		if entryColumns[field] {
+			p.columns = append(p.columns, field)
+			continue
+		}
+		if path, ok := parseProjectedDataPath(field); ok {
+			p.paths = append(p.paths, projectedPath{name: field, path: path})
+			continue
+		}
+		unknown = append(unknown, field)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+	}
+	return p, nil
+}
+
+// Adds columns missing from the projection, unless it selects all columns anyway.
+func (p *projection) require(columns ...string) {
+	if len(p.columns) == 0 && len(p.paths) == 0 {
+		return
+	}
+	for _, column := range columns {
+		if !contains(p.columns, column) {
+			p.columns = append(p.columns, column)
+		}
+	}
+}
+
+// Select expressions for the projection, nil selects all columns.
+func (p *projection) selectExprs() []string {
+	if len(p.columns) == 0 && len(p.paths) == 0 {
+		return nil
+	}
+	exprs := []string{}
+	for _, column := range p.columns {
+		exprs = append(exprs, fmt.Sprintf("\"entries\".\"%s\"", column))
+	}
+	// Path segments are restricted by parseDataPath so they are safe to inline.
+	for _, pp := range p.paths {
+		exprs = append(exprs, fmt.Sprintf("\"entries\".\"data\" #> '{%s}' AS \"%s\"", strings.Join(pp.path, ","), pp.name))
+	}
+	return exprs
+}
+
+// Maps the entry columns of rows selected by the projection's select expressions,
+// computed once per query and passed to scanEntry.
+func (p *projection) bindMapping(columns []string) ([]uint64, error) {
+	entryCols := columns[:len(columns)-len(p.paths)]
+	mapping, err := queries.BindMapping(entryType, entryMapping, entryCols)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "bind mapping")
+	}
+	return mapping, nil
+}
+
+// Scans a row selected by the projection's select expressions.
+func (p *projection) scanEntry(rows *sql.Rows, mapping []uint64) (*ScanEntry, error) {
+	e := &ScanEntry{Entry: &models.Entry{}}
+	ptrs := queries.PtrsFromMapping(reflect.Indirect(reflect.ValueOf(e.Entry)), mapping)
+	values := make([]null.JSON, len(p.paths))
+	for i := range values {
+		ptrs = append(ptrs, &values[i])
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, pkgerr.Wrap(err, "scan entry")
+	}
+	if len(p.paths) > 0 {
+		e.Paths = make(map[string]null.JSON, len(p.paths))
+		for i, pp := range p.paths {
+			e.Paths[pp.name] = values[i]
+		}
+	}
+	return e, nil
+}
+
+// Queries entries with the projection applied, mods should not select columns.
+func (p *projection) queryEntries(exec boil.Executor, mods ...qm.QueryMod) ([]*ScanEntry, error) {
+	if exprs := p.selectExprs(); exprs != nil {
+		mods = append([]qm.QueryMod{qm.Select(exprs...)}, mods...)
+	}
+	rows, err := models.Entries(mods...).Query.Query(exec)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "query entries")
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "rows.Columns")
+	}
+	mapping, err := p.bindMapping(columns)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*ScanEntry{}
+	for rows.Next() {
+		entry, err := p.scanEntry(rows, mapping)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pkgerr.Wrap(err, "iterate entries")
+	}
+	return entries, nil
+}
+
+// Marshals the entry fields along with the projected data paths.
+func (e ScanEntry) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(e.Entry)
+	if err != nil || len(e.Paths) == 0 {
+		return b, err
+	}
+
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for name, value := range e.Paths {
+		if !value.Valid {
+			m[name] = json.RawMessage("null")
+		} else {
+			m[name] = json.RawMessage(value.JSON)
+		}
+	}
+	return json.Marshal(m)
+}
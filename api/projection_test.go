@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type ProjectionSuite struct {
+	suite.Suite
+}
+
+func TestProjection(t *testing.T) {
+	suite.Run(t, new(ProjectionSuite))
+}
+
+func (suite *ProjectionSuite) TestParseFields() {
+	p, err := parseFields([]string{"id", "namespace", "data.search.query"})
+	suite.Require().Nil(err)
+	suite.Equal([]string{
+		"\"entries\".\"id\"",
+		"\"entries\".\"namespace\"",
+		"\"entries\".\"data\" #> '{search,query}' AS \"data.search.query\"",
+	}, p.selectExprs())
+
+	p, err = parseFields(nil)
+	suite.Require().Nil(err)
+	suite.Nil(p.selectExprs())
+
+	_, err = parseFields([]string{"id", "password", "count(*)", "data.a'b"})
+	suite.EqualError(err, "unknown fields: password, count(*), data.a'b")
+}
+
+func (suite *ProjectionSuite) TestRequire() {
+	p, err := parseFields([]string{"data.query"})
+	suite.Require().Nil(err)
+	p.require("id", "created_at", "id")
+	suite.Equal([]string{"id", "created_at"}, p.columns)
+
+	p, err = parseFields([]string{"namespace", "id"})
+	suite.Require().Nil(err)
+	p.require("id", "created_at")
+	suite.Equal([]string{"namespace", "id", "created_at"}, p.columns)
+
+	p, err = parseFields(nil)
+	suite.Require().Nil(err)
+	p.require("id")
+	suite.Nil(p.selectExprs())
+}
+
+func (suite *ProjectionSuite) TestMarshalPaths() {
+	e := ScanEntry{
+		Entry: &models.Entry{ID: "1"},
+		Paths: map[string]null.JSON{
+			"data.query":   null.JSONFrom([]byte(`"light"`)),
+			"data.missing": {},
+		},
+	}
+	b, err := json.Marshal(e)
+	suite.Require().Nil(err)
+	m := map[string]interface{}{}
+	suite.Require().Nil(json.Unmarshal(b, &m))
+	suite.Equal("1", m["id"])
+	suite.Equal("light", m["data.query"])
+	suite.Contains(m, "data.missing")
+	suite.Nil(m["data.missing"])
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+)
+
+// Limits the entries per user and namespace. Returns which entries are dropped and,
+// if any, how long until they'd be allowed.
+func limitEntries(c *gin.Context, entries []*models.Entry) ([]bool, time.Duration) {
+	dropped := make([]bool, len(entries))
+	limiter := middleware.GetRateLimiter(c)
+	if limiter == nil {
+		return dropped, 0
+	}
+
+	var retryAfter time.Duration
+	// Entries dropped by a previous kind aren't counted again.
+	limit := func(kind string, value func(e *models.Entry) string) {
+		counts := map[string]int{}
+		values := []string{}
+		for i, e := range entries {
+			if dropped[i] {
+				continue
+			}
+			v := value(e)
+			if _, ok := counts[v]; !ok {
+				values = append(values, v)
+			}
+			counts[v]++
+		}
+		for _, v := range values {
+			ok, wait := limiter.Allow(kind, v, counts[v])
+			if ok {
+				continue
+			}
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+			for i, e := range entries {
+				if value(e) == v {
+					dropped[i] = true
+				}
+			}
+		}
+	}
+	limit(ratelimit.USER, func(e *models.Entry) string { return e.UserID })
+	limit(ratelimit.NAMESPACE, func(e *models.Entry) string { return e.Namespace })
+	return dropped, retryAfter
+}
+
+// RateLimitsHandler returns the events dropped by rate limits per key.
+func RateLimitsHandler(c *gin.Context) {
+	limiter := middleware.GetRateLimiter(c)
+	if limiter == nil {
+		concludeRequest(c, nil, httputil.NewNotFoundError())
+		return
+	}
+	dropped, since := limiter.Dropped()
+	concludeRequest(c, RateLimitsResponse{Since: since, Dropped: dropped}, nil)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+)
+
+type RateLimitSuite struct {
+	suite.Suite
+}
+
+func TestRateLimit(t *testing.T) {
+	suite.Run(t, new(RateLimitSuite))
+}
+
+func (suite *RateLimitSuite) TestLimitEntries() {
+	config := ratelimit.Config{
+		User:       ratelimit.Limit{Rate: 1, Burst: 2},
+		Namespaces: map[string]ratelimit.Limit{"archive": {Rate: 1, Burst: 1}, "kmedia": {Rate: 1, Burst: 1}},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("RATE_LIMITER", ratelimit.NewLimiter(ratelimit.NewMemoryStore(), config, zerolog.Nop()))
+
+	entries := []*models.Entry{
+		{UserID: "a", Namespace: "kmedia"},
+		{UserID: "a", Namespace: "kmedia"},
+		{UserID: "a", Namespace: "kmedia"},
+		{UserID: "b", Namespace: "kmedia"},
+		{UserID: "c", Namespace: "archive"},
+		{UserID: "d", Namespace: "archive"},
+	}
+	dropped, retryAfter := limitEntries(c, entries)
+	// User a exceeds its burst, archive its namespace's. The entries of user a don't count for kmedia.
+	suite.Equal([]bool{true, true, true, false, true, true}, dropped)
+	suite.True(retryAfter > 0)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	dropped, _ = limitEntries(c, entries)
+	suite.Equal(make([]bool, len(entries)), dropped)
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pkgerr "github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/apikey"
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/schema"
+)
+
+// Reads the body to store it if rejected, binding reads it again.
+func rawBody(c *gin.Context) ([]byte, bool) {
+	body, err := c.GetRawData()
+	if err != nil {
+		httputil.NewBadRequestError(err).Abort(c)
+		return nil, false
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// Stores the body rejected for reason, along with what's known of the client.
+func rejectBody(c *gin.Context, endpoint string, batchIndex null.Int, body []byte, r AppendRequest, reason error) {
+	recorder := middleware.GetRejected(c)
+	if recorder == nil {
+		return
+	}
+	e := &rejected.Entry{
+		Endpoint:        endpoint,
+		BatchIndex:      batchIndex,
+		Namespace:       r.Namespace,
+		ClientEventType: r.ClientEventType,
+		Reason:          reason.Error(),
+		Body:            rejected.Body(body),
+		IPAddr:          c.ClientIP(),
+		UserAgent:       c.Request.UserAgent(),
+	}
+	if claims := middleware.GetClaims(c); claims != nil {
+		e.KeycloakSubject = null.StringFrom(claims.Subject)
+	}
+	if key := middleware.GetAPIKey(c); key != nil {
+		e.APIKeyID = null.StringFrom(key.ID)
+	}
+	recorder.Record(e)
+}
+
+// Stores the append requests of /appends rejected by validation, each by itself.
+func rejectAppends(c *gin.Context, body []byte, r AppendsRequest, resp *AppendsResponse) {
+	if middleware.GetRejected(c) == nil {
+		return
+	}
+	var raw struct {
+		AppendRequests []json.RawMessage `json:"append_requests"`
+	}
+	for i, result := range resp.Results {
+		if !result.invalid {
+			continue
+		}
+		if raw.AppendRequests == nil {
+			if err := json.Unmarshal(body, &raw); err != nil || len(raw.AppendRequests) != len(resp.Results) {
+				// Already bound, shouldn't happen.
+				rejectBody(c, rejected.ENDPOINT_APPENDS, null.Int{}, body, AppendRequest{}, errors.New(result.Error))
+				return
+			}
+		}
+		rejectBody(c, rejected.ENDPOINT_APPENDS, null.IntFrom(i), raw.AppendRequests[i], r.AppendRequests[i].Append, errors.New(result.Error))
+	}
+}
+
+// RejectedHandler scans the entries rejected by validation, oldest first.
+func RejectedHandler(c *gin.Context) {
+	r := RejectedRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	resp, err := handleRejected(c.MustGet("DB").(*sql.DB), r)
+	concludeRequest(c, resp, err)
+}
+
+func handleRejected(exec boil.Executor, r RejectedRequest) (*RejectedResponse, *httputil.HttpError) {
+	if r.Limit <= 0 || r.Limit > rejected.MAX_LIMIT {
+		r.Limit = DEFAULT_LIMIT
+	}
+	filter := rejected.Filter{
+		Namespaces:       r.Namespaces,
+		ClientEventTypes: r.ClientEventTypes,
+		From:             r.From,
+		To:               r.To,
+		Replayed:         null.BoolFromPtr(r.Replayed),
+		After:            r.After,
+		Limit:            r.Limit,
+	}
+	entries, err := rejected.Scan(exec, filter)
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+	resp := &RejectedResponse{Entries: entries}
+	if len(entries) == r.Limit {
+		resp.Next = entries[len(entries)-1].ID
+	}
+	return resp, nil
+}
+
+// ReplayRejected validates the appends of a rejected entry again, returning their entries as
+// created when rejected. keycloak_id must be the token subject back then and the scope of the
+// API key still applies, daily quotas and rate limits don't. Dead letters of ingestion return
+// the entry itself. Entries are as old as the rejected ones, with any offset, and are queued
+// for rollups when written, see rollup.EnqueueLate.
+func ReplayRejected(exec boil.Executor, schemas *schema.Registry, e *rejected.Entry) ([]*models.Entry, error) {
+	// Dead letters of ingestion were validated when accepted, they keep their id.
+	if e.Endpoint == rejected.ENDPOINT_INGEST {
+		entry := &models.Entry{}
+		if err := json.Unmarshal([]byte(e.Body), entry); err != nil {
+			return nil, pkgerr.Wrap(err, "parse entry")
+		}
+		return []*models.Entry{entry}, nil
+	}
+
+	requests, err := rejectedRequests(e)
+	if err != nil {
+		return nil, err
+	}
+	var key *apikey.Key
+	if e.APIKeyID.Valid {
+		if key, err = apikey.Find(exec, e.APIKeyID.String); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]*models.Entry, len(requests))
+	for i, r := range requests {
+		if err := validateReplay(schemas, e, key, r.Append); err != nil {
+			return nil, fmt.Errorf("append %d: %w", i, err)
+		}
+		then := e.CreatedAt.Add(time.Duration(r.Offset) * time.Millisecond)
+		id, err := ksuid.NewRandomWithTime(then)
+		if err != nil {
+			return nil, pkgerr.Wrap(err, "new ksuid")
+		}
+		entries[i] = buildEntry(id.String(), then, e.IPAddr, e.UserAgent, r.Append)
+	}
+	return entries, nil
+}
+
+func rejectedRequests(e *rejected.Entry) ([]AppendOffsetRequest, error) {
+	switch {
+	case e.BatchIndex.Valid:
+		r := AppendOffsetRequest{}
+		if err := json.Unmarshal([]byte(e.Body), &r); err != nil {
+			return nil, pkgerr.Wrap(err, "parse append request")
+		}
+		return []AppendOffsetRequest{r}, nil
+	case e.Endpoint == rejected.ENDPOINT_APPENDS:
+		r := AppendsRequest{}
+		if err := json.Unmarshal([]byte(e.Body), &r); err != nil {
+			return nil, pkgerr.Wrap(err, "parse appends request")
+		}
+		return r.AppendRequests, nil
+	default:
+		r := AppendRequest{}
+		if err := json.Unmarshal([]byte(e.Body), &r); err != nil {
+			return nil, pkgerr.Wrap(err, "parse append request")
+		}
+		return []AppendOffsetRequest{{Append: r}}, nil
+	}
+}
+
+func validateReplay(schemas *schema.Registry, e *rejected.Entry, key *apikey.Key, r AppendRequest) error {
+	if err := validateAppend(r); err != nil {
+		return err
+	}
+	if keycloakID := valueOrEmpty(r.KeycloakId); keycloakID != "" && !common.Config.SkipAuth && keycloakID != e.KeycloakSubject.String {
+		return errors.New("expected keycloak_id to be the token subject")
+	}
+	if key != nil && !key.Allows(r.Namespace, r.ClientEventType) {
+		return fmt.Errorf("API key %s does not allow %s events in namespace %s", key.ID, r.ClientEventType, r.Namespace)
+	}
+	if schemas == nil {
+		return nil
+	}
+	err := schemas.Validate(r.Namespace, r.ClientEventType, r.Data.JSON)
+	var violation *schema.ViolationError
+	if errors.As(err, &violation) && violation.Mode != schema.MODE_ENFORCE {
+		return nil
+	}
+	return err
+}
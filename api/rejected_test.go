@@ -0,0 +1,105 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+type RejectedSuite struct {
+	suite.Suite
+}
+
+func TestRejected(t *testing.T) {
+	suite.Run(t, new(RejectedSuite))
+}
+
+func (suite *RejectedSuite) TestReplayRejected() {
+	common.Init()
+	createdAt := time.Date(2025, 11, 4, 9, 0, 0, 0, time.UTC)
+	e := &rejected.Entry{
+		CreatedAt: createdAt,
+		Endpoint:  rejected.ENDPOINT_APPEND,
+		Body:      `{"client_id": "abc", "namespace": "archive", "client_event_type": "page-view", "data": {"page": "/"}}`,
+		IPAddr:    "10.0.0.1",
+		UserAgent: "Mozilla/5.0",
+	}
+	entries, err := ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 1)
+	suite.Equal(CLIENT_USER_ID_PREFIX+"abc", entries[0].UserID)
+	suite.Equal(createdAt, entries[0].CreatedAt)
+	suite.Equal("10.0.0.1", entries[0].IPAddr)
+	suite.Equal("Mozilla/5.0", entries[0].UserAgent)
+	id, err := ksuid.Parse(entries[0].ID)
+	suite.Require().Nil(err)
+	suite.Equal(createdAt, id.Time().UTC())
+
+	// Still invalid.
+	e.Body = `{"namespace": "archive", "client_event_type": "page-view"}`
+	_, err = ReplayRejected(nil, nil, e)
+	suite.NotNil(err)
+	e.Body = `{"namespace": `
+	_, err = ReplayRejected(nil, nil, e)
+	suite.NotNil(err)
+
+	// keycloak_id must have been verified.
+	e.Body = `{"keycloak_id": "user-1", "namespace": "archive", "client_event_type": "page-view"}`
+	_, err = ReplayRejected(nil, nil, e)
+	suite.NotNil(err)
+	e.KeycloakSubject = null.StringFrom("user-1")
+	entries, err = ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Equal("user-1", entries[0].UserID)
+}
+
+func (suite *RejectedSuite) TestReplayRejectedAppends() {
+	common.Init()
+	createdAt := time.Date(2025, 11, 4, 9, 0, 0, 0, time.UTC)
+	e := &rejected.Entry{
+		CreatedAt:  createdAt,
+		Endpoint:   rejected.ENDPOINT_APPENDS,
+		BatchIndex: null.IntFrom(3),
+		Body:       `{"offset": -1500, "append": {"client_id": "abc", "namespace": "archive", "client_event_type": "page-view"}}`,
+		IPAddr:     "10.0.0.1",
+	}
+	entries, err := ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 1)
+	suite.Equal(createdAt.Add(-1500*time.Millisecond), entries[0].CreatedAt)
+
+	// The whole body when it couldn't be bound.
+	e.BatchIndex = null.Int{}
+	e.Body = `{"append_requests": [{"append": {"client_id": "abc", "namespace": "archive", "client_event_type": "a"}}, {"offset": -1500, "append": {"client_id": "abc", "namespace": "archive", "client_event_type": "b"}}]}`
+	entries, err = ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 2)
+	suite.Equal("b", entries[1].ClientEventType)
+	suite.True(entries[1].ID < entries[0].ID)
+
+	// Offsets aren't bounded, old entries are queued for rollups.
+	e.Body = `{"append_requests": [{"offset": -172800000, "append": {"client_id": "abc", "namespace": "archive", "client_event_type": "a"}}]}`
+	entries, err = ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 1)
+	suite.Equal(createdAt.AddDate(0, 0, -2), entries[0].CreatedAt)
+	suite.Equal([]string{entries[0].ID}, rollup.Late(entries, time.Now()))
+}
+
+func (suite *RejectedSuite) TestReplayRejectedIngest() {
+	e := &rejected.Entry{
+		Endpoint: rejected.ENDPOINT_INGEST,
+		Body:     `{"id": "2EHqDQdm3ZHTHWw8jGqzjcvBg1P", "namespace": "archive", "client_event_type": "page-view"}`,
+	}
+	entries, err := ReplayRejected(nil, nil, e)
+	suite.Require().Nil(err)
+	suite.Require().Len(entries, 1)
+	suite.Equal("2EHqDQdm3ZHTHWw8jGqzjcvBg1P", entries[0].ID)
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+const (
+	DEFAULT_RETENTION_PERIODS = 8
+	MAX_RETENTION_PERIODS     = 90
+)
+
+var retentionPeriodDays = map[string]int{"day": 1, "week": 7}
+
+type retentionRow struct {
+	Cohort   time.Time `boil:"cohort"`
+	Keycloak bool      `boil:"keycloak"`
+	// Null for the number of users in the cohort.
+	Period null.Int `boil:"period"`
+	Users  int64    `boil:"users"`
+}
+
+// Users of each cohort, from first_seen, and users of each cohort active in every following
+// period, from entries. Entries are scanned from the first cohort until activityTo.
+func retentionQuery(r RetentionRequest, period string, periods int, activityTo time.Time) (string, []interface{}) {
+	days := retentionPeriodDays[period]
+	query := fmt.Sprintf(`WITH cohorts AS (
+  SELECT user_id, date_trunc('%[1]s', first_seen_at AT TIME ZONE 'UTC') AS cohort, user_id NOT LIKE $2 AS keycloak
+  FROM first_seen WHERE namespace = $1 AND first_seen_at >= $3 AND first_seen_at < $4
+), activity AS (
+  SELECT DISTINCT user_id, date_trunc('%[1]s', created_at AT TIME ZONE 'UTC') AS active
+  FROM entries WHERE namespace = $1 AND created_at >= $3 AND created_at < $5
+)
+SELECT c.cohort, c.keycloak, NULL::int AS period, count(*) AS users FROM cohorts AS c
+GROUP BY c.cohort, c.keycloak
+UNION ALL
+SELECT c.cohort, c.keycloak, (a.active::date - c.cohort::date) / %[2]d AS period, count(*) AS users
+FROM cohorts AS c JOIN activity AS a ON a.user_id = c.user_id
+WHERE a.active >= c.cohort AND a.active < c.cohort + $6::int * interval '1 day'
+GROUP BY 1, 2, 3
+ORDER BY 1, 2, 3`, period, days)
+
+	args := []interface{}{
+		r.Namespace,
+		CLIENT_USER_ID_PREFIX + "%",
+		r.From,
+		r.To,
+		activityTo,
+		(periods + 1) * days,
+	}
+	return query, args
+}
+
+// RetentionHandler reports, for cohorts of users by the day or week of their first entry
+// in a namespace, how many of them return in the following periods.
+// Keycloak users and client ids are reported separately.
+func RetentionHandler(c *gin.Context) {
+	r := RetentionRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	resp, err := handleRetention(c, r)
+	concludeRequest(c, resp, err)
+}
+
+func handleRetention(c *gin.Context, r RetentionRequest) (*RetentionResponse, *httputil.HttpError) {
+	if err := checkNamespaces(middleware.GetPolicy(c), r.Namespace); err != nil {
+		return nil, err
+	}
+	if !r.From.Before(r.To) {
+		return nil, httputil.NewBadRequestError(errors.New("expected from to be before to"))
+	}
+	if r.To.Sub(r.From) > common.Config.AggregateMaxRange {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected range of at most %s", common.Config.AggregateMaxRange))
+	}
+	period := "week"
+	if r.Period != "" {
+		period = r.Period
+	}
+	days, ok := retentionPeriodDays[period]
+	if !ok {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("unknown period %q, expected day or week", period))
+	}
+	periods := DEFAULT_RETENTION_PERIODS
+	if r.Periods != 0 {
+		periods = r.Periods
+	}
+	if periods < 0 || periods > MAX_RETENTION_PERIODS {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected at most %d periods", MAX_RETENTION_PERIODS))
+	}
+
+	now := time.Now().UTC()
+	activityTo := r.To.AddDate(0, 0, (periods+1)*days)
+	if activityTo.After(now) {
+		activityTo = now
+	}
+	query, args := retentionQuery(r, period, periods, activityTo)
+
+	db := c.MustGet("DB").(*sql.DB)
+	log := c.MustGet("LOGGER").(zerolog.Logger)
+	rows := []retentionRow{}
+	var covered time.Time
+	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		var err error
+		if covered, err = rollup.FirstSeen.CoveredUntil(tx); err != nil {
+			return err
+		}
+		timeout := common.Config.AggregateTimeout.Milliseconds()
+		if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)); err != nil {
+			return pkgerr.Wrap(err, "set statement_timeout")
+		}
+		return pkgerr.Wrap(queries.Raw(query, args...).Bind(nil, tx, &rows), "retention query")
+	})
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+
+	return &RetentionResponse{Cohorts: retentionCohorts(rows, days, periods, now), CoveredUntil: covered}, nil
+}
+
+// Periods yet to start are left out.
+func retentionCohorts(rows []retentionRow, days, periods int, now time.Time) []RetentionCohort {
+	type key struct {
+		cohort   time.Time
+		keycloak bool
+	}
+	cohorts := []RetentionCohort{}
+	index := map[key]int{}
+	for _, row := range rows {
+		k := key{cohort: row.Cohort, keycloak: row.Keycloak}
+		i, ok := index[k]
+		if !ok {
+			started := periods + 1
+			for started > 1 && row.Cohort.AddDate(0, 0, (started-1)*days).After(now) {
+				started--
+			}
+			i = len(cohorts)
+			index[k] = i
+			cohorts = append(cohorts, RetentionCohort{
+				Cohort:    row.Cohort.Format("2006-01-02"),
+				Keycloak:  row.Keycloak,
+				Active:    make([]int64, started),
+				Retention: make([]float64, started),
+			})
+		}
+		if !row.Period.Valid {
+			cohorts[i].Users = row.Users
+		} else if row.Period.Int < len(cohorts[i].Active) {
+			cohorts[i].Active[row.Period.Int] = row.Users
+		}
+	}
+	for i := range cohorts {
+		if cohorts[i].Users == 0 {
+			continue
+		}
+		for j, active := range cohorts[i].Active {
+			cohorts[i].Retention[j] = float64(active) / float64(cohorts[i].Users)
+		}
+	}
+	return cohorts
+}
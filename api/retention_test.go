@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+type RetentionSuite struct {
+	suite.Suite
+}
+
+func TestRetention(t *testing.T) {
+	suite.Run(t, new(RetentionSuite))
+}
+
+func (suite *RetentionSuite) TestRetentionQuery() {
+	r := RetentionRequest{
+		Namespace: "archive",
+		From:      time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2025, 2, 3, 0, 0, 0, 0, time.UTC),
+	}
+	query, args := retentionQuery(r, "week", 4, time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC))
+	suite.Contains(query, "date_trunc('week', first_seen_at AT TIME ZONE 'UTC') AS cohort")
+	suite.Contains(query, "(a.active::date - c.cohort::date) / 7 AS period")
+	suite.Equal("client:%", args[1])
+	suite.Equal(35, args[5])
+}
+
+func (suite *RetentionSuite) TestRetentionCohorts() {
+	week1 := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	week2 := week1.AddDate(0, 0, 7)
+	rows := []retentionRow{
+		{Cohort: week1, Keycloak: false, Period: null.IntFrom(0), Users: 10},
+		{Cohort: week1, Keycloak: false, Period: null.IntFrom(2), Users: 4},
+		{Cohort: week1, Keycloak: false, Users: 10},
+		{Cohort: week1, Keycloak: true, Period: null.IntFrom(0), Users: 5},
+		{Cohort: week1, Keycloak: true, Period: null.IntFrom(1), Users: 5},
+		{Cohort: week1, Keycloak: true, Users: 5},
+		{Cohort: week2, Keycloak: true, Period: null.IntFrom(0), Users: 2},
+		{Cohort: week2, Keycloak: true, Users: 2},
+	}
+	// The third period of the second cohort didn't start yet.
+	now := week2.AddDate(0, 0, 10)
+	cohorts := retentionCohorts(rows, 7, 2, now)
+	suite.Require().Len(cohorts, 3)
+
+	suite.Equal("2025-01-06", cohorts[0].Cohort)
+	suite.False(cohorts[0].Keycloak)
+	suite.EqualValues(10, cohorts[0].Users)
+	suite.Equal([]int64{10, 0, 4}, cohorts[0].Active)
+	suite.Equal([]float64{1, 0, 0.4}, cohorts[0].Retention)
+
+	suite.True(cohorts[1].Keycloak)
+	suite.Equal([]int64{5, 5, 0}, cohorts[1].Active)
+
+	suite.Equal("2025-01-13", cohorts[2].Cohort)
+	suite.Equal([]int64{2, 0}, cohorts[2].Active)
+	suite.Equal([]float64{1, 0}, cohorts[2].Retention)
+}
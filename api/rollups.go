@@ -0,0 +1,213 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+const (
+	SOURCE_ENTRIES = "entries"
+	SOURCE_ROLLUPS = "rollups"
+)
+
+var rollupTimeBuckets = map[string]bool{"": true, "day": true, "week": true, "month": true, "year": true}
+
+func isMidnight(t time.Time) bool {
+	return t.Equal(rollup.Day(t))
+}
+
+// Truncates a UTC day to the start of its bucket, weeks start on Monday like with date_trunc.
+func truncateDay(day time.Time, bucket string) time.Time {
+	switch bucket {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		return time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// Whether the aggregation can be computed from daily rollups, regardless of their progress.
+func rollupsSupport(r AggregateRequest) bool {
+	f := r.ScanFilters
+	if len(f.UserIds) > 0 || f.Keycloak.Valid || len(f.ClientEventIds) > 0 || len(f.ClientFlowIds) > 0 ||
+		len(f.ClientFlowTypes) > 0 || len(f.ClientSessionIds) > 0 || len(f.DataFilters) > 0 {
+		return false
+	}
+	if !isMidnight(f.From.Time) || !isMidnight(f.To.Time) {
+		return false
+	}
+	for _, dimension := range r.GroupBy {
+		if dimension != models.EntryColumns.Namespace && dimension != models.EntryColumns.ClientEventType {
+			return false
+		}
+	}
+	if !rollupTimeBuckets[r.TimeBucket] {
+		return false
+	}
+	for _, metric := range r.Metrics {
+		if metric.Op == "count" && metric.Field == "" {
+			continue
+		}
+		if metric.Op == "count_distinct" && metric.Field == models.EntryColumns.UserID {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+type rollupGroup struct {
+	values []string
+	bucket time.Time
+	rollup *rollup.Rollup
+}
+
+// Aggregates daily rollups like the aggregation query over entries.
+// Returns at most limit+1 rows.
+func aggregateRollups(exec boil.Executor, r AggregateRequest, limit int) ([]AggregateRow, error) {
+	rollups, err := rollup.Load(exec, rollup.Filter{
+		From:              r.From.Time,
+		To:                r.To.Time,
+		Namespaces:        r.Namespaces,
+		ClientEventTypes:  r.EventTypes,
+		NamespacePrefixes: r.namespacePrefixes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*rollupGroup{}
+	for _, ru := range rollups {
+		g := &rollupGroup{values: make([]string, len(r.GroupBy))}
+		for i, dimension := range r.GroupBy {
+			if dimension == models.EntryColumns.Namespace {
+				g.values[i] = ru.Namespace
+			} else {
+				g.values[i] = ru.ClientEventType
+			}
+		}
+		if r.TimeBucket != "" {
+			g.bucket = truncateDay(ru.Day, r.TimeBucket)
+		}
+		key := strings.Join(g.values, "\x00") + "\x00" + g.bucket.Format(time.RFC3339)
+		if existing, ok := groups[key]; ok {
+			existing.rollup.Merge(ru)
+			continue
+		}
+		g.rollup = rollup.New(ru.Key)
+		g.rollup.Merge(ru)
+		groups[key] = g
+	}
+	// Without dimensions there is a single row, even when nothing matched.
+	if len(r.GroupBy) == 0 && r.TimeBucket == "" && len(groups) == 0 {
+		groups[""] = &rollupGroup{rollup: rollup.New(rollup.Key{})}
+	}
+
+	sorted := make([]*rollupGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		for k := range sorted[i].values {
+			if sorted[i].values[k] != sorted[j].values[k] {
+				return sorted[i].values[k] < sorted[j].values[k]
+			}
+		}
+		return sorted[i].bucket.Before(sorted[j].bucket)
+	})
+	if len(sorted) > limit+1 {
+		sorted = sorted[:limit+1]
+	}
+
+	rows := make([]AggregateRow, len(sorted))
+	for i, g := range sorted {
+		row := AggregateRow{Group: map[string]interface{}{}, Metrics: map[string]interface{}{}}
+		for k, dimension := range r.GroupBy {
+			value := g.values[k]
+			row.Group[dimension] = &value
+		}
+		if r.TimeBucket != "" {
+			row.Group[TIME_DIMENSION] = g.bucket.Format(time.RFC3339)
+		}
+		for _, metric := range r.Metrics {
+			var value float64
+			if metric.Op == "count" {
+				value = float64(g.rollup.Events)
+			} else {
+				value = float64(g.rollup.Users.Estimate())
+			}
+			row.Metrics[metric.name()] = &value
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// Loads the daily rollups of the requested namespaces for days in [r.From, to).
+func visitorsRollups(exec boil.Executor, r VisitorsRequest, to time.Time) ([]*rollup.Rollup, error) {
+	return rollup.Load(exec, rollup.Filter{
+		From:              r.From,
+		To:                to,
+		Namespaces:        r.Namespaces,
+		NamespacePrefixes: r.namespacePrefixes,
+	})
+}
+
+// Counts visitors from daily rollups like visitorsQuery.
+func visitorsPeriods(rollups []*rollup.Rollup, granularities []string) []VisitorsPeriod {
+	type period struct {
+		namespace   string
+		granularity string
+		start       time.Time
+	}
+	periods := map[period]*rollup.Rollup{}
+	for _, ru := range rollups {
+		for _, g := range granularities {
+			p := period{namespace: ru.Namespace, granularity: g, start: truncateDay(ru.Day, g)}
+			merged, ok := periods[p]
+			if !ok {
+				merged = rollup.New(ru.Key)
+				periods[p] = merged
+			}
+			merged.Merge(ru)
+		}
+	}
+
+	sorted := make([]period, 0, len(periods))
+	for p := range periods {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.granularity != b.granularity {
+			return a.granularity < b.granularity
+		}
+		return a.start.Before(b.start)
+	})
+
+	visitors := make([]VisitorsPeriod, len(sorted))
+	for i, p := range sorted {
+		merged := periods[p]
+		visitors[i] = VisitorsPeriod{
+			Namespace:   p.namespace,
+			Granularity: p.granularity,
+			Period:      p.start.Format(granularityFormats[p.granularity]),
+			Visitors:    int64(merged.Users.Estimate()),
+			Incognito:   int64(merged.IncognitoUsers.Estimate()),
+			LoggedIn:    int64(merged.LoggedInUsers.Estimate()),
+		}
+	}
+	return visitors
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+type RollupsSuite struct {
+	suite.Suite
+}
+
+func TestRollups(t *testing.T) {
+	suite.Run(t, new(RollupsSuite))
+}
+
+func (suite *RollupsSuite) TestTruncateDay() {
+	// Wednesday.
+	day := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	suite.Equal(day, truncateDay(day, "day"))
+	suite.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), truncateDay(day, "week"))
+	suite.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), truncateDay(day, "month"))
+	suite.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), truncateDay(day, "year"))
+
+	// Sunday belongs to the week started on Monday before.
+	sunday := time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC)
+	suite.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), truncateDay(sunday, "week"))
+	monday := time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC)
+	suite.Equal(monday, truncateDay(monday, "week"))
+}
+
+func (suite *RollupsSuite) TestRollupsSupport() {
+	newRequest := func() AggregateRequest {
+		r := AggregateRequest{
+			GroupBy:    []string{"namespace", "client_event_type"},
+			TimeBucket: "week",
+			Metrics:    []AggregateMetric{{Op: "count"}, {Op: "count_distinct", Field: "user_id"}},
+		}
+		r.Namespaces = []string{"archive"}
+		r.From = null.TimeFrom(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+		r.To = null.TimeFrom(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC))
+		return r
+	}
+	suite.True(rollupsSupport(newRequest()))
+
+	r := newRequest()
+	r.To = null.TimeFrom(time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC))
+	suite.False(rollupsSupport(r))
+
+	r = newRequest()
+	r.TimeBucket = "hour"
+	suite.False(rollupsSupport(r))
+
+	r = newRequest()
+	r.GroupBy = []string{"client_flow_type"}
+	suite.False(rollupsSupport(r))
+
+	r = newRequest()
+	r.Metrics = []AggregateMetric{{Op: "count_distinct", Field: "client_session_id"}}
+	suite.False(rollupsSupport(r))
+
+	r = newRequest()
+	r.UserIds = []string{"client:1"}
+	suite.False(rollupsSupport(r))
+}
@@ -2,11 +2,43 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/apikey"
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
 )
 
-func SetupRoutes(router *gin.Engine) {
-	router.POST("/append", AppendHandler)
-	router.POST("/appends", AppendsHandler)
+func SetupRoutes(router *gin.Engine, keys *apikey.Verifier) {
+	// Anonymous clients append too, keycloak_id requires a token (see authorizeAppend).
+	// API keys are required with REQUIRE_API_KEY, their scope and quota are checked by the handlers.
+	// Requests are rate limited per client IP, events per user and namespace by the handlers.
+	rateLimit := middleware.RateLimitMiddleware()
+	apiKey := middleware.APIKeyMiddleware(keys, common.Config.RequireAPIKey)
+	router.POST("/append", rateLimit, apiKey, AppendHandler)
+	router.POST("/appends", rateLimit, apiKey, AppendsHandler)
 	router.GET("/health_check", HealthCheckHandler)
-	router.POST("/scan", ScanHandler)
+
+	// Reads are restricted by the policy of the role, see access.go.
+	read := router.Group("/", middleware.RequireRole(middleware.ROLE_ANALYST, middleware.ROLE_ADMIN))
+	read.POST("/scan", ScanHandler)
+	read.POST("/export", ExportHandler)
+	read.GET("/stats/visitors", VisitorsHandler)
+	read.POST("/aggregate", AggregateHandler)
+	read.POST("/funnels", FunnelsHandler)
+	read.GET("/retention", RetentionHandler)
+	read.GET("/users/:id/timeline", UserTimelineHandler)
+	read.GET("/flows/:flow_id", FlowHandler)
+	read.GET("/entries", EntriesHandler)
+	read.POST("/entries/lookup", EntriesLookupHandler)
+	read.GET("/entries/:id", EntryHandler)
+	read.GET("/tail", TailHandler)
+
+	admin := router.Group("/", middleware.RequireRole(middleware.ROLE_ADMIN))
+	admin.GET("/rate_limits", RateLimitsHandler)
+	admin.GET("/rejected", RejectedHandler)
+	admin.GET("/schemas", SchemasHandler)
+	admin.POST("/schemas", RegisterSchemaHandler)
+	admin.GET("/schemas/:namespace/:event_type", SchemaVersionsHandler)
+	admin.GET("/schemas/:namespace/:event_type/:version", SchemaHandler)
+	admin.PATCH("/schemas/:namespace/:event_type/:version", SchemaModeHandler)
 }
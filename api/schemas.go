@@ -0,0 +1,158 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/schema"
+)
+
+// SchemasHandler returns the latest version of each event schema.
+func SchemasHandler(c *gin.Context) {
+	r := SchemasRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	schemas, err := schema.Latest(c.MustGet("DB").(*sql.DB), r.Namespace)
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewInternalError(err))
+		return
+	}
+	concludeRequest(c, schemas, nil)
+}
+
+// SchemaVersionsHandler returns all versions of an event schema, latest first.
+func SchemaVersionsHandler(c *gin.Context) {
+	schemas, err := schema.Versions(c.MustGet("DB").(*sql.DB), c.Param("namespace"), c.Param("event_type"))
+	if err != nil {
+		concludeRequest(c, nil, httputil.NewInternalError(err))
+		return
+	}
+	if len(schemas) == 0 {
+		concludeRequest(c, nil, httputil.NewNotFoundError())
+		return
+	}
+	concludeRequest(c, schemas, nil)
+}
+
+// SchemaHandler returns a version of an event schema, or the latest one.
+func SchemaHandler(c *gin.Context) {
+	version, httpErr := schemaVersion(c)
+	if httpErr != nil {
+		concludeRequest(c, nil, httpErr)
+		return
+	}
+	s, err := schema.Find(c.MustGet("DB").(*sql.DB), c.Param("namespace"), c.Param("event_type"), version)
+	concludeRequest(c, s, schemaError(err))
+}
+
+// RegisterSchemaHandler stores a new version of an event schema, appends are validated
+// against it from then on.
+func RegisterSchemaHandler(c *gin.Context) {
+	r := RegisterSchemaRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	resp, err := handleRegisterSchema(c.MustGet("DB").(*sql.DB), c.MustGet("LOGGER").(zerolog.Logger), r)
+	if err == nil {
+		forgetSchema(c, r.Namespace, r.ClientEventType)
+	}
+	concludeRequest(c, resp, err)
+}
+
+func handleRegisterSchema(db boil.Beginner, log zerolog.Logger, r RegisterSchemaRequest) (*schema.Schema, *httputil.HttpError) {
+	if r.Mode == "" {
+		r.Mode = schema.MODE_ENFORCE
+	}
+	if !schema.ValidMode(r.Mode) {
+		return nil, httputil.NewBadRequestError(schema.ErrInvalidMode)
+	}
+	// Invalid schemas are the client's fault, compile before storing.
+	if _, err := schema.Compile(r.Schema); err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	var s *schema.Schema
+	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		var err error
+		s, err = schema.Register(tx, r.Namespace, r.ClientEventType, r.Schema, r.Mode)
+		return err
+	})
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+	return s, nil
+}
+
+// SchemaModeHandler changes the mode of an event schema version, e.g., from warn to enforce.
+func SchemaModeHandler(c *gin.Context) {
+	r := SchemaModeRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+	version, httpErr := schemaVersion(c)
+	if httpErr != nil {
+		concludeRequest(c, nil, httpErr)
+		return
+	}
+	if !schema.ValidMode(r.Mode) {
+		concludeRequest(c, nil, httputil.NewBadRequestError(schema.ErrInvalidMode))
+		return
+	}
+
+	db := c.MustGet("DB").(*sql.DB)
+	namespace, eventType := c.Param("namespace"), c.Param("event_type")
+	if version == 0 {
+		latest, err := schema.Find(db, namespace, eventType, 0)
+		if err != nil {
+			concludeRequest(c, nil, schemaError(err))
+			return
+		}
+		version = latest.Version
+	}
+	s, err := schema.SetMode(db, namespace, eventType, version, r.Mode)
+	if err == nil {
+		forgetSchema(c, namespace, eventType)
+	}
+	concludeRequest(c, s, schemaError(err))
+}
+
+// The :version parameter, 0 for "latest".
+func schemaVersion(c *gin.Context) (int, *httputil.HttpError) {
+	param := c.Param("version")
+	if param == "latest" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(param)
+	if err != nil || version < 1 {
+		return 0, httputil.NewBadRequestError(errors.New("expected version to be a positive number or latest"))
+	}
+	return version, nil
+}
+
+func schemaError(err error) *httputil.HttpError {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, schema.ErrNotFound):
+		return httputil.NewNotFoundError()
+	default:
+		return httputil.NewInternalError(err)
+	}
+}
+
+// Changes apply immediately on this replica, others reload after SCHEMA_CACHE_TTL.
+func forgetSchema(c *gin.Context, namespace, clientEventType string) {
+	if schemas := middleware.GetSchemas(c); schemas != nil {
+		schemas.Forget(namespace, clientEventType)
+	}
+}
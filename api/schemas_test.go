@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+)
+
+type SchemasSuite struct {
+	suite.Suite
+}
+
+func TestSchemas(t *testing.T) {
+	suite.Run(t, new(SchemasSuite))
+}
+
+func (suite *SchemasSuite) TestRegisterInvalid() {
+	for _, r := range []RegisterSchemaRequest{
+		{Namespace: "archive", ClientEventType: "player-play", Schema: []byte(`{"type": "object"}`), Mode: "strict"},
+		{Namespace: "archive", ClientEventType: "player-play", Schema: []byte(`{"type": "nope"}`)},
+		{Namespace: "archive", ClientEventType: "player-play", Schema: []byte(`{`)},
+	} {
+		// Rejected before touching the DB.
+		_, err := handleRegisterSchema(nil, zerolog.Nop(), r)
+		suite.Require().NotNil(err, string(r.Schema))
+		suite.Equal(http.StatusBadRequest, err.Code, string(r.Schema))
+	}
+}
+
+func (suite *SchemasSuite) TestSchemaVersion() {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	for param, expected := range map[string]int{"latest": 0, "1": 1, "12": 12} {
+		c.Params = gin.Params{{Key: "version", Value: param}}
+		version, err := schemaVersion(c)
+		suite.Nil(err, param)
+		suite.Equal(expected, version, param)
+	}
+	for _, param := range []string{"", "0", "-1", "v2"} {
+		c.Params = gin.Params{{Key: "version", Value: param}}
+		_, err := schemaVersion(c)
+		suite.Require().NotNil(err, param)
+		suite.Equal(http.StatusBadRequest, err.Code, param)
+	}
+}
+
+func (suite *SchemasSuite) TestValidateSchemaWithoutRegistry() {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	suite.Nil(validateSchema(c, AppendRequest{Namespace: "archive", ClientEventType: "player-play"}))
+}
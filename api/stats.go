@@ -0,0 +1,239 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+var granularityFormats = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+type visitorsRow struct {
+	Namespace   string    `boil:"namespace"`
+	Granularity string    `boil:"granularity"`
+	Period      time.Time `boil:"period"`
+	Visitors    int64     `boil:"visitors"`
+	Incognito   int64     `boil:"incognito"`
+	LoggedIn    int64     `boil:"logged_in"`
+}
+
+// VisitorsHandler counts distinct users per namespace and period (UTC).
+// Incognito users have client ids prefixed by INCOGNITO_USER_ID_PREFIX,
+// logged in users are keycloak users, i.e., without CLIENT_USER_ID_PREFIX.
+// Days covered by daily rollups are served from these, as estimates, and entries are
+// counted only for the remaining days, at most AggregateMaxRange, with a statement timeout.
+func VisitorsHandler(c *gin.Context) {
+	r := VisitorsRequest{}
+	if c.Bind(&r) != nil {
+		return
+	}
+
+	policy := middleware.GetPolicy(c)
+	if err := checkNamespaces(policy, r.Namespaces...); err != nil {
+		concludeRequest(c, nil, err)
+		return
+	}
+	r.namespacePrefixes = namespacePrefixes(policy, r.Namespaces)
+
+	resp, err := handleVisitors(c.MustGet("DB").(*sql.DB), c.MustGet("LOGGER").(zerolog.Logger), r)
+	concludeRequest(c, resp, err)
+}
+
+func handleVisitors(db *sql.DB, log zerolog.Logger, r VisitorsRequest) (*VisitorsResponse, *httputil.HttpError) {
+	if !r.From.Before(r.To) {
+		return nil, httputil.NewBadRequestError(errors.New("expected from to be before to"))
+	}
+	granularities := r.Granularities
+	if len(granularities) == 0 {
+		granularities = []string{"day", "month", "year"}
+	}
+
+	query, args, err := visitorsQuery(r, granularities)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+
+	split := r.From
+	if covered, err := rollup.DailyRollups.CoveredUntil(db); err != nil {
+		log.Warn().Err(err).Msg("Daily rollups progress, counting entries")
+	} else {
+		split = visitorsSplit(r, covered)
+	}
+	if r.To.Sub(split) > common.Config.AggregateMaxRange {
+		return nil, httputil.NewBadRequestError(fmt.Errorf("expected range not covered by rollups of at most %s", common.Config.AggregateMaxRange))
+	}
+
+	if split.After(r.From) {
+		rollups, err := visitorsRollups(db, r, split)
+		if err != nil {
+			return nil, httputil.NewInternalError(err)
+		}
+		if split.Before(r.To) {
+			tail, err := visitorsTail(db, log, r, split)
+			if err != nil {
+				return nil, httputil.NewInternalError(err)
+			}
+			rollups = append(rollups, tail...)
+		}
+		return &VisitorsResponse{Visitors: visitorsPeriods(rollups, granularities), Source: SOURCE_ROLLUPS}, nil
+	}
+
+	rows := []visitorsRow{}
+	err = withAggregateTimeout(db, log, func(tx *sql.Tx) error {
+		return queries.Raw(query, args...).Bind(nil, tx, &rows)
+	})
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+
+	resp := &VisitorsResponse{Visitors: make([]VisitorsPeriod, len(rows)), Source: SOURCE_ENTRIES}
+	for i, row := range rows {
+		resp.Visitors[i] = VisitorsPeriod{
+			Namespace:   row.Namespace,
+			Granularity: row.Granularity,
+			Period:      row.Period.Format(granularityFormats[row.Granularity]),
+			Visitors:    row.Visitors,
+			Incognito:   row.Incognito,
+			LoggedIn:    row.LoggedIn,
+		}
+	}
+	return resp, nil
+}
+
+// Days before the returned one are served from rollups, clamped to the requested range.
+func visitorsSplit(r VisitorsRequest, covered time.Time) time.Time {
+	split := rollup.Day(covered)
+	if split.Before(r.From) {
+		return r.From
+	}
+	if split.After(r.To) {
+		return r.To
+	}
+	return split
+}
+
+type visitorsTailRow struct {
+	Namespace string    `boil:"namespace"`
+	Day       time.Time `boil:"day"`
+	UserID    string    `boil:"user_id"`
+}
+
+// Rolls up the distinct users of entries created from from until r.To, per namespace and day,
+// to be merged with the stored rollups.
+func visitorsTail(db *sql.DB, log zerolog.Logger, r VisitorsRequest, from time.Time) ([]*rollup.Rollup, error) {
+	where, args := visitorsWhere(r, from, nil)
+	query := fmt.Sprintf(`SELECT DISTINCT namespace, date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, user_id
+FROM entries WHERE %s`, where)
+
+	rows := []visitorsTailRow{}
+	err := withAggregateTimeout(db, log, func(tx *sql.Tx) error {
+		return queries.Raw(query, args...).Bind(nil, tx, &rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := map[rollup.Key]*rollup.Rollup{}
+	for _, row := range rows {
+		key := rollup.Key{Day: rollup.Day(row.Day), Namespace: row.Namespace}
+		ru, ok := rollups[key]
+		if !ok {
+			ru = rollup.New(key)
+			rollups[key] = ru
+		}
+		ru.Add(row.UserID)
+	}
+	tail := make([]*rollup.Rollup, 0, len(rollups))
+	for _, ru := range rollups {
+		tail = append(tail, ru)
+	}
+	return tail, nil
+}
+
+// Runs f in a transaction limited by AggregateTimeout.
+func withAggregateTimeout(db *sql.DB, log zerolog.Logger, f func(tx *sql.Tx) error) error {
+	return sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		timeout := common.Config.AggregateTimeout.Milliseconds()
+		if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)); err != nil {
+			return pkgerr.Wrap(err, "set statement_timeout")
+		}
+		return f(tx)
+	})
+}
+
+// Filters entries created in [from, r.To) of the requested namespaces, args are appended to.
+func visitorsWhere(r VisitorsRequest, from time.Time, args []interface{}) (string, []interface{}) {
+	args = append(args, from, r.To)
+	where := fmt.Sprintf("created_at >= $%d AND created_at < $%d", len(args)-1, len(args))
+	if len(r.Namespaces) > 0 {
+		placeholders := make([]string, len(r.Namespaces))
+		for i, namespace := range r.Namespaces {
+			args = append(args, namespace)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		where += fmt.Sprintf(" AND namespace IN (%s)", strings.Join(placeholders, ", "))
+	}
+	if len(r.namespacePrefixes) > 0 {
+		args = append(args, pq.Array(sqlutil.LikePrefixes(r.namespacePrefixes)))
+		where += fmt.Sprintf(" AND namespace LIKE ANY($%d)", len(args))
+	}
+	return where, args
+}
+
+// A single scan computes all granularities with grouping sets.
+func visitorsQuery(r VisitorsRequest, granularities []string) (string, []interface{}, error) {
+	selects := []string{}
+	sets := []string{}
+	for _, g := range granularities {
+		if _, ok := granularityFormats[g]; !ok {
+			return "", nil, fmt.Errorf("unknown granularity %q, expected day, month or year", g)
+		}
+		selects = append(selects, fmt.Sprintf("date_trunc('%s', created_at AT TIME ZONE 'UTC') AS period_%s", g, g))
+		sets = append(sets, fmt.Sprintf("(namespace, period_%s)", g))
+	}
+	granularity := "CASE"
+	period := "COALESCE("
+	for i, g := range granularities {
+		granularity += fmt.Sprintf(" WHEN GROUPING(period_%s) = 0 THEN '%s'", g, g)
+		if i > 0 {
+			period += ", "
+		}
+		period += "period_" + g
+	}
+	granularity += " END"
+	period += ")"
+
+	where, args := visitorsWhere(r, r.From, []interface{}{
+		INCOGNITO_USER_ID_PREFIX + "%",
+		CLIENT_USER_ID_PREFIX + "%",
+	})
+
+	query := fmt.Sprintf(`SELECT namespace, %s AS granularity, %s AS period,
+  count(DISTINCT user_id) AS visitors,
+  count(DISTINCT user_id) FILTER (WHERE user_id LIKE $1) AS incognito,
+  count(DISTINCT user_id) FILTER (WHERE user_id NOT LIKE $2) AS logged_in
+FROM (SELECT namespace, user_id, %s FROM entries WHERE %s) AS e
+GROUP BY GROUPING SETS (%s)
+ORDER BY namespace, granularity, period`,
+		granularity, period, strings.Join(selects, ", "), where, strings.Join(sets, ", "))
+
+	return query, args, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+type StatsSuite struct {
+	suite.Suite
+}
+
+func TestStats(t *testing.T) {
+	suite.Run(t, new(StatsSuite))
+}
+
+func (suite *StatsSuite) TestVisitorsQuery() {
+	r := VisitorsRequest{
+		From:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		Namespaces: []string{"archive", "kmedia"},
+	}
+	query, args, err := visitorsQuery(r, []string{"day", "month"})
+	suite.Require().Nil(err)
+	suite.Contains(query, "GROUP BY GROUPING SETS ((namespace, period_day), (namespace, period_month))")
+	suite.Contains(query, "AND namespace IN ($5, $6)")
+	suite.Equal("client:local:%", args[0])
+	suite.Equal("client:%", args[1])
+
+	_, _, err = visitorsQuery(r, []string{"week"})
+	suite.NotNil(err)
+}
+
+func (suite *StatsSuite) TestVisitorsSplit() {
+	r := VisitorsRequest{
+		From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	suite.Equal(r.From, visitorsSplit(r, time.Time{}))
+	suite.Equal(time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC), visitorsSplit(r, time.Date(2025, 1, 20, 13, 0, 0, 0, time.UTC)))
+	suite.Equal(r.To, visitorsSplit(r, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func (suite *StatsSuite) TestVisitorsPeriods() {
+	stored := rollup.New(rollup.Key{Day: time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC), Namespace: "archive", ClientEventType: "page-view"})
+	stored.Add("client:local:a")
+	stored.Add("keycloak-b")
+	tail := rollup.New(rollup.Key{Day: time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC), Namespace: "archive"})
+	tail.Add("keycloak-b")
+	tail.Add("keycloak-c")
+
+	visitors := visitorsPeriods([]*rollup.Rollup{stored, tail}, []string{"day", "month"})
+	suite.Equal([]VisitorsPeriod{
+		{Namespace: "archive", Granularity: "day", Period: "2025-01-19", Visitors: 2, Incognito: 1, LoggedIn: 1},
+		{Namespace: "archive", Granularity: "day", Period: "2025-01-20", Visitors: 2, Incognito: 0, LoggedIn: 2},
+		{Namespace: "archive", Granularity: "month", Period: "2025-01", Visitors: 3, Incognito: 1, LoggedIn: 2},
+	}, visitors)
+}
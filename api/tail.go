@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/tail"
+)
+
+const (
+	TAIL_HEARTBEAT     = 15 * time.Second
+	TAIL_WRITE_TIMEOUT = 10 * time.Second
+	TAIL_SLOW_CONSUMER = "slow consumer"
+)
+
+var tailUpgrader = websocket.Upgrader{
+	// Like the CORS policy, any origin is allowed.
+	CheckOrigin: func(r *http.Request) bool { return true },
+	// Selected when the client passes its token as a subprotocol.
+	Subprotocols: []string{middleware.ACCESS_TOKEN_PROTOCOL},
+}
+
+func (r TailRequest) filters() ScanFilters {
+	f := ScanFilters{
+		EventTypes:       r.EventTypes,
+		UserIds:          r.UserIds,
+		Namespaces:       r.Namespaces,
+		ClientFlowIds:    r.ClientFlowIds,
+		ClientFlowTypes:  r.ClientFlowTypes,
+		ClientSessionIds: r.ClientSessionIds,
+	}
+	if r.Keycloak != nil {
+		f.Keycloak = null.BoolFrom(*r.Keycloak)
+	}
+	return f
+}
+
+// TailHandler streams newly appended entries matching the filters, as Server-Sent Events
+// or, on upgrade requests, over a WebSocket. Subscribers not keeping up with the entries
+// are disconnected.
+func TailHandler(c *gin.Context) {
+	r := TailRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	policy := middleware.GetPolicy(c)
+	f := r.filters()
+	if err := f.restrict(policy); err != nil {
+		concludeRequest(c, nil, err)
+		return
+	}
+
+	hub := c.MustGet("TAIL").(*tail.Hub)
+	sub := hub.Subscribe(f.matches, common.Config.TailBufferSize)
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		tailWebSocket(c, sub, policy)
+	} else {
+		tailEvents(c, sub, policy)
+	}
+}
+
+func tailEvents(c *gin.Context, sub *tail.Subscription, policy *middleware.Policy) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Disables response buffering of nginx.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(TAIL_HEARTBEAT)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Done():
+			if sub.Dropped() {
+				c.SSEvent("error", gin.H{"error": TAIL_SLOW_CONSUMER})
+				c.Writer.Flush()
+			}
+			return
+		case e := <-sub.Entries():
+			c.SSEvent("entry", maskEntry(policy, e))
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			c.Writer.Flush()
+		}
+	}
+}
+
+func tailWebSocket(c *gin.Context, sub *tail.Subscription, policy *middleware.Policy) {
+	conn, err := tailUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	// Reading handles control frames and tells when the client goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(TAIL_HEARTBEAT)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-sub.Done():
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+			if sub.Dropped() {
+				message = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, TAIL_SLOW_CONSUMER)
+			}
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(TAIL_WRITE_TIMEOUT))
+			return
+		case e := <-sub.Entries():
+			conn.SetWriteDeadline(time.Now().Add(TAIL_WRITE_TIMEOUT))
+			if err := conn.WriteJSON(maskEntry(policy, e)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(TAIL_WRITE_TIMEOUT)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/tail"
+)
+
+type TailSuite struct {
+	suite.Suite
+}
+
+func TestTail(t *testing.T) {
+	suite.Run(t, new(TailSuite))
+}
+
+func (suite *TailSuite) SetupSuite() {
+	common.Init()
+	gin.SetMode(gin.TestMode)
+}
+
+func (suite *TailSuite) TestMatches() {
+	keycloak := true
+	f := TailRequest{Namespaces: []string{"archive"}, ClientFlowTypes: []string{"search"}, Keycloak: &keycloak}.filters()
+
+	e := &models.Entry{Namespace: "archive", UserID: "keycloak-id", ClientFlowType: null.StringFrom("search")}
+	suite.True(f.matches(e))
+	e.UserID = "client:local:1"
+	suite.False(f.matches(e))
+	e.UserID = "keycloak-id"
+	e.ClientFlowType = null.String{}
+	suite.False(f.matches(e))
+	e.ClientFlowType = null.StringFrom("search")
+	e.Namespace = "kmedia"
+	suite.False(f.matches(e))
+
+	suite.True(ScanFilters{}.matches(e))
+}
+
+func (suite *TailSuite) TestWebSocket() {
+	hub := tail.NewHub()
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("TAIL", hub) })
+	router.GET("/tail", TailHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/tail?namespaces=archive"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	suite.Require().Nil(err)
+	defer conn.Close()
+
+	for hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish([]*models.Entry{{ID: "1", Namespace: "kmedia"}, {ID: "2", Namespace: "archive"}})
+
+	var entry models.Entry
+	suite.Require().Nil(conn.ReadJSON(&entry))
+	suite.Equal("2", entry.ID)
+
+	hub.Close()
+	_, _, err = conn.ReadMessage()
+	suite.True(websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+)
+
+const (
+	DEFAULT_TIMELINE_RANGE = 7 * 24 * time.Hour
+	DEFAULT_SESSION_GAP    = 30 * time.Minute
+	MAX_TIMELINE_LIMIT     = 10000
+)
+
+// Groups entries, ordered by created_at, into sessions by client_session_id.
+// Consecutive entries without client_session_id form inferred sessions, ended when
+// no entry follows within gap.
+func buildSessions(entries []*models.Entry, gap time.Duration) []*TimelineSession {
+	sessions := []*TimelineSession{}
+	byID := map[string]*TimelineSession{}
+	var inferred *TimelineSession
+	for _, e := range entries {
+		var s *TimelineSession
+		if e.ClientSessionID.Valid {
+			s = byID[e.ClientSessionID.String]
+			if s == nil {
+				s = &TimelineSession{ClientSessionId: e.ClientSessionID}
+				byID[e.ClientSessionID.String] = s
+			}
+		} else {
+			if inferred == nil || e.CreatedAt.Sub(inferred.End) > gap {
+				inferred = &TimelineSession{Inferred: true}
+			}
+			s = inferred
+		}
+
+		if len(s.Entries) == 0 {
+			s.Start = e.CreatedAt
+			s.EventCounts = map[string]int{}
+			sessions = append(sessions, s)
+		}
+		s.End = e.CreatedAt
+		s.EventCounts[e.ClientEventType]++
+		s.Entries = append(s.Entries, e)
+	}
+	for _, s := range sessions {
+		s.Duration = s.End.Sub(s.Start).Seconds()
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions
+}
+
+func parseTimelineRequest(r TimelineRequest) (time.Duration, int, error) {
+	gap := DEFAULT_SESSION_GAP
+	if r.Gap != "" {
+		var err error
+		if gap, err = time.ParseDuration(r.Gap); err != nil || gap <= 0 {
+			return 0, 0, fmt.Errorf("invalid gap %q", r.Gap)
+		}
+	}
+	limit := DEFAULT_LIMIT
+	if r.Limit != 0 {
+		limit = r.Limit
+	}
+	if limit < 0 || limit > MAX_TIMELINE_LIMIT {
+		return 0, 0, fmt.Errorf("expected limit of at most %d", MAX_TIMELINE_LIMIT)
+	}
+	return gap, limit, nil
+}
+
+// Queries the most recent entries, returned in created_at order.
+func queryTimeline(db *sql.DB, policy *middleware.Policy, limit int, gap time.Duration, mods ...qm.QueryMod) (*TimelineResponse, error) {
+	mods = append(mods,
+		qm.OrderBy(fmt.Sprintf("%s DESC, %s DESC", models.EntryColumns.CreatedAt, models.EntryColumns.ID)),
+		// One more entry tells whether the timeline was truncated.
+		qm.Limit(limit+1))
+	entries, err := models.Entries(mods...).All(db)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &TimelineResponse{}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		resp.Truncated = true
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	for i, e := range entries {
+		entries[i] = maskEntry(policy, e)
+	}
+	resp.Sessions = buildSessions(entries, gap)
+	return resp, nil
+}
+
+// UserTimelineHandler returns the entries of a user in a time range grouped into sessions.
+func UserTimelineHandler(c *gin.Context) {
+	r := TimelineRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	resp, err := handleUserTimeline(c.MustGet("DB").(*sql.DB), middleware.GetPolicy(c), c.Param("id"), r)
+	concludeRequest(c, resp, err)
+}
+
+func handleUserTimeline(db *sql.DB, policy *middleware.Policy, userID string, r TimelineRequest) (*TimelineResponse, *httputil.HttpError) {
+	gap, limit, err := parseTimelineRequest(r)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+	to := r.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := r.From
+	if from.IsZero() {
+		from = to.Add(-DEFAULT_TIMELINE_RANGE)
+	}
+	if !from.Before(to) {
+		return nil, httputil.NewBadRequestError(errors.New("expected from to be before to"))
+	}
+
+	mods := []qm.QueryMod{
+		models.EntryWhere.UserID.EQ(userID),
+		models.EntryWhere.CreatedAt.GTE(from),
+		models.EntryWhere.CreatedAt.LT(to),
+	}
+	namespaceMods, httpErr := namespaceMods(policy, r.Namespaces)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	mods = append(mods, namespaceMods...)
+	resp, err := queryTimeline(db, policy, limit, gap, mods...)
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+	return resp, nil
+}
+
+// FlowHandler returns the entries of a client flow grouped into sessions.
+func FlowHandler(c *gin.Context) {
+	r := TimelineRequest{}
+	if c.BindQuery(&r) != nil {
+		return
+	}
+
+	resp, err := handleFlow(c.MustGet("DB").(*sql.DB), middleware.GetPolicy(c), c.Param("flow_id"), r)
+	concludeRequest(c, resp, err)
+}
+
+func handleFlow(db *sql.DB, policy *middleware.Policy, flowID string, r TimelineRequest) (*TimelineResponse, *httputil.HttpError) {
+	gap, limit, err := parseTimelineRequest(r)
+	if err != nil {
+		return nil, httputil.NewBadRequestError(err)
+	}
+
+	mods := []qm.QueryMod{models.EntryWhere.ClientFlowID.EQ(null.StringFrom(flowID))}
+	if !r.From.IsZero() {
+		mods = append(mods, models.EntryWhere.CreatedAt.GTE(r.From))
+	}
+	if !r.To.IsZero() {
+		mods = append(mods, models.EntryWhere.CreatedAt.LT(r.To))
+	}
+	namespaceMods, httpErr := namespaceMods(policy, r.Namespaces)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	mods = append(mods, namespaceMods...)
+	resp, err := queryTimeline(db, policy, limit, gap, mods...)
+	if err != nil {
+		return nil, httputil.NewInternalError(err)
+	}
+	return resp, nil
+}
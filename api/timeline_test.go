@@ -0,0 +1,70 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type TimelineSuite struct {
+	suite.Suite
+}
+
+func TestTimeline(t *testing.T) {
+	suite.Run(t, new(TimelineSuite))
+}
+
+func (suite *TimelineSuite) TestBuildSessions() {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	entry := func(minutes int, eventType string, sessionID null.String) *models.Entry {
+		return &models.Entry{
+			CreatedAt:       start.Add(time.Duration(minutes) * time.Minute),
+			ClientEventType: eventType,
+			ClientSessionID: sessionID,
+		}
+	}
+	entries := []*models.Entry{
+		entry(0, "page-enter", null.String{}),
+		entry(5, "search", null.StringFrom("s1")),
+		entry(10, "click", null.String{}),
+		entry(50, "play", null.StringFrom("s1")),
+		// More than the gap since the last entry without session.
+		entry(60, "page-enter", null.String{}),
+		entry(70, "page-leave", null.String{}),
+	}
+
+	sessions := buildSessions(entries, 30*time.Minute)
+	suite.Require().Len(sessions, 3)
+
+	suite.True(sessions[0].Inferred)
+	suite.Equal(start, sessions[0].Start)
+	suite.Equal(600.0, sessions[0].Duration)
+	suite.Equal(map[string]int{"page-enter": 1, "click": 1}, sessions[0].EventCounts)
+
+	suite.False(sessions[1].Inferred)
+	suite.Equal("s1", sessions[1].ClientSessionId.String)
+	suite.Equal(45*60.0, sessions[1].Duration)
+	suite.Len(sessions[1].Entries, 2)
+
+	suite.True(sessions[2].Inferred)
+	suite.Equal(start.Add(60*time.Minute), sessions[2].Start)
+	suite.Len(sessions[2].Entries, 2)
+
+	suite.Empty(buildSessions(nil, time.Minute))
+}
+
+func (suite *TimelineSuite) TestParseTimelineRequest() {
+	gap, limit, err := parseTimelineRequest(TimelineRequest{})
+	suite.Nil(err)
+	suite.Equal(DEFAULT_SESSION_GAP, gap)
+	suite.Equal(DEFAULT_LIMIT, limit)
+
+	_, _, err = parseTimelineRequest(TimelineRequest{Gap: "soon"})
+	suite.NotNil(err)
+	_, _, err = parseTimelineRequest(TimelineRequest{Limit: MAX_TIMELINE_LIMIT + 1})
+	suite.NotNil(err)
+}
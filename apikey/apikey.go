@@ -0,0 +1,200 @@
+// Package apikey manages ingestion API keys. A key is scoped to namespace
+// prefixes and client event types and may be limited to a number of events
+// per UTC day. Only a hash of the key's secret is stored, the token is shown
+// once when the key is created.
+//
+// Tokens are formatted as chr_<id>_<secret>, the id being the key's KSUID.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+const (
+	TOKEN_PREFIX = "chr_"
+	SECRET_BYTES = 32
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid API key")
+	ErrNotFound     = errors.New("API key not found")
+)
+
+type Key struct {
+	ID         string         `boil:"id" json:"id"`
+	Name       string         `boil:"name" json:"name"`
+	SecretHash []byte         `boil:"secret_hash" json:"-"`
+	Namespaces pq.StringArray `boil:"namespaces" json:"namespaces"`
+	EventTypes pq.StringArray `boil:"event_types" json:"event_types"`
+	DailyQuota int64          `boil:"daily_quota" json:"daily_quota"`
+	CreatedAt  time.Time      `boil:"created_at" json:"created_at"`
+	ExpiresAt  null.Time      `boil:"expires_at" json:"expires_at"`
+	RevokedAt  null.Time      `boil:"revoked_at" json:"revoked_at"`
+	ReplacedBy null.String    `boil:"replaced_by" json:"replaced_by"`
+}
+
+// Allows returns whether the key writes the event type into the namespace.
+func (k *Key) Allows(namespace, eventType string) bool {
+	if len(k.EventTypes) > 0 && !contains(k.EventTypes, eventType) {
+		return false
+	}
+	if len(k.Namespaces) == 0 {
+		return true
+	}
+	for _, prefix := range k.Namespaces {
+		if strings.HasPrefix(namespace, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns whether the key is neither revoked nor expired at t.
+func (k *Key) Active(t time.Time) bool {
+	if k.RevokedAt.Valid && !t.Before(k.RevokedAt.Time) {
+		return false
+	}
+	return !k.ExpiresAt.Valid || t.Before(k.ExpiresAt.Time)
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hashSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+func newToken(id string) (string, []byte, error) {
+	b := make([]byte, SECRET_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, pkgerr.Wrap(err, "random secret")
+	}
+	secret := hex.EncodeToString(b)
+	return fmt.Sprintf("%s%s_%s", TOKEN_PREFIX, id, secret), hashSecret(secret), nil
+}
+
+// ParseToken splits a token into the key id and its secret.
+func ParseToken(token string) (string, string, error) {
+	if !strings.HasPrefix(token, TOKEN_PREFIX) {
+		return "", "", ErrInvalidToken
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, TOKEN_PREFIX), "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", ErrInvalidToken
+	}
+	if _, err := ksuid.Parse(parts[0]); err != nil {
+		return "", "", ErrInvalidToken
+	}
+	return parts[0], parts[1], nil
+}
+
+// Matches returns whether the secret is the key's, in constant time.
+func (k *Key) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare(k.SecretHash, hashSecret(secret)) == 1
+}
+
+// Create stores a new key and returns it with its token.
+func Create(exec boil.Executor, name string, namespaces, eventTypes []string, dailyQuota int64) (*Key, string, error) {
+	id := ksuid.New().String()
+	token, hash, err := newToken(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	key := &Key{}
+	err = queries.Raw(`INSERT INTO api_keys (id, name, secret_hash, namespaces, event_types, daily_quota)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING *`,
+		id, name, hash, pq.Array(namespaces), pq.Array(eventTypes), dailyQuota).Bind(nil, exec, key)
+	if err != nil {
+		return nil, "", pkgerr.Wrap(err, "insert api key")
+	}
+	return key, token, nil
+}
+
+// Find returns the key of the id, ErrNotFound if there's none.
+func Find(exec boil.Executor, id string) (*Key, error) {
+	key := &Key{}
+	if err := queries.Raw("SELECT * FROM api_keys WHERE id = $1", id).Bind(nil, exec, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, pkgerr.Wrap(err, "select api key")
+	}
+	return key, nil
+}
+
+// List returns all keys, revoked and expired ones included.
+func List(exec boil.Executor) ([]*Key, error) {
+	keys := []*Key{}
+	if err := queries.Raw("SELECT * FROM api_keys ORDER BY id").Bind(nil, exec, &keys); err != nil {
+		return nil, pkgerr.Wrap(err, "select api keys")
+	}
+	return keys, nil
+}
+
+// Revoke stops the key from being accepted.
+func Revoke(exec boil.Executor, id string) error {
+	res, err := exec.Exec("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return pkgerr.Wrap(err, "revoke api key")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return pkgerr.Wrap(err, "rows affected")
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Rotate replaces the key with a new one of the same scope and quota. The replaced
+// key keeps being accepted for the grace period, letting clients switch tokens.
+// Callers should pass a transaction.
+func Rotate(exec boil.Executor, id string, grace time.Duration) (*Key, string, error) {
+	old := &Key{}
+	err := queries.Raw("SELECT * FROM api_keys WHERE id = $1 AND revoked_at IS NULL FOR UPDATE", id).Bind(nil, exec, old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", pkgerr.Wrap(err, "select api key")
+	}
+	key, token, err := Create(exec, old.Name, old.Namespaces, old.EventTypes, old.DailyQuota)
+	if err != nil {
+		return nil, "", err
+	}
+	expiresAt := time.Now().Add(grace)
+	if old.ExpiresAt.Valid && old.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = old.ExpiresAt.Time
+	}
+	if _, err := exec.Exec("UPDATE api_keys SET expires_at = $2, replaced_by = $3 WHERE id = $1", id, expiresAt, key.ID); err != nil {
+		return nil, "", pkgerr.Wrap(err, "expire rotated api key")
+	}
+	return key, token, nil
+}
@@ -0,0 +1,128 @@
+package apikey
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+type APIKeySuite struct {
+	suite.Suite
+}
+
+func TestAPIKey(t *testing.T) {
+	suite.Run(t, new(APIKeySuite))
+}
+
+func (suite *APIKeySuite) TestToken() {
+	token, hash, err := newToken("2EHqDQdm3ZHTHWw8jGqzjcvBg1P")
+	suite.Require().Nil(err)
+	suite.True(strings.HasPrefix(token, "chr_2EHqDQdm3ZHTHWw8jGqzjcvBg1P_"))
+
+	id, secret, err := ParseToken(token)
+	suite.Require().Nil(err)
+	suite.Equal("2EHqDQdm3ZHTHWw8jGqzjcvBg1P", id)
+	key := &Key{ID: id, SecretHash: hash}
+	suite.True(key.Matches(secret))
+	suite.False(key.Matches(secret + "0"))
+
+	for _, invalid := range []string{"", "chr_", "2EHqDQdm3ZHTHWw8jGqzjcvBg1P_abc", "chr_2EHqDQdm3ZHTHWw8jGqzjcvBg1P", "chr_short_abc"} {
+		_, _, err := ParseToken(invalid)
+		suite.Equal(ErrInvalidToken, err, invalid)
+	}
+}
+
+func (suite *APIKeySuite) TestAllows() {
+	key := &Key{}
+	suite.True(key.Allows("archive", "player-play"))
+
+	key.Namespaces = []string{"archive_", "kmedia"}
+	suite.True(key.Allows("archive_v1.2", "player-play"))
+	suite.True(key.Allows("kmedia", "player-play"))
+	suite.False(key.Allows("archive", "player-play"))
+
+	key.EventTypes = []string{"player-play"}
+	suite.True(key.Allows("kmedia", "player-play"))
+	suite.False(key.Allows("kmedia", "search"))
+}
+
+func (suite *APIKeySuite) TestActive() {
+	now := time.Now()
+	key := &Key{}
+	suite.True(key.Active(now))
+	key.ExpiresAt = null.TimeFrom(now.Add(time.Hour))
+	suite.True(key.Active(now))
+	suite.False(key.Active(now.Add(time.Hour)))
+	key.RevokedAt = null.TimeFrom(now.Add(-time.Minute))
+	suite.False(key.Active(now))
+}
+
+func (suite *APIKeySuite) TestVerify() {
+	id := "2EHqDQdm3ZHTHWw8jGqzjcvBg1P"
+	token, hash, err := newToken(id)
+	suite.Require().Nil(err)
+
+	// Cached keys are not looked up.
+	v := NewVerifier(nil, time.Hour)
+	v.cache[id] = cachedKey{key: &Key{ID: id, SecretHash: hash}, loadedAt: time.Now()}
+	key, err := v.Verify(token)
+	suite.Require().Nil(err)
+	suite.Equal(id, key.ID)
+
+	_, err = v.Verify(token[:len(token)-1] + "x")
+	suite.Equal(ErrInvalidToken, err)
+
+	key.RevokedAt = null.TimeFrom(time.Now().Add(-time.Minute))
+	_, err = v.Verify(token)
+	suite.Equal(ErrInactive, err)
+
+	// Unknown ids are cached too.
+	other := "2EHqDQdm3ZHTHWw8jGqzjcvBg1Q"
+	v.cache[other] = cachedKey{loadedAt: time.Now()}
+	_, err = v.Verify(TOKEN_PREFIX + other + "_secret")
+	suite.Equal(ErrInvalidToken, err)
+}
+
+// Fails every statement like a DB that is down.
+type downExecutor struct{}
+
+func (downExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (downExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (downExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	panic("unexpected QueryRow")
+}
+
+func (suite *APIKeySuite) TestVerifyStale() {
+	id := "2EHqDQdm3ZHTHWw8jGqzjcvBg1P"
+	token, hash, err := newToken(id)
+	suite.Require().Nil(err)
+
+	// Expired cache entries are used while the DB is down.
+	v := NewVerifier(downExecutor{}, time.Minute)
+	v.cache[id] = cachedKey{key: &Key{ID: id, SecretHash: hash}, loadedAt: time.Now().Add(-time.Hour)}
+	key, err := v.Verify(token)
+	suite.Require().Nil(err)
+	suite.Equal(id, key.ID)
+
+	// Keys never looked up can't be verified.
+	other, _, err := newToken("2EHqDQdm3ZHTHWw8jGqzjcvBg1Q")
+	suite.Require().Nil(err)
+	_, err = v.Verify(other)
+	suite.ErrorIs(err, driver.ErrBadConn)
+}
+
+func (suite *APIKeySuite) TestDay() {
+	t := time.Date(2025, 11, 1, 23, 30, 0, 0, time.FixedZone("IST", 2*60*60))
+	suite.Equal(time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), Day(t))
+}
@@ -0,0 +1,59 @@
+package apikey
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+var ErrQuotaExceeded = errors.New("API key daily quota exceeded")
+
+// Day returns the UTC day of t, as quotas are counted.
+func Day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Consume counts n events of the key on the day of now. Nothing is counted and
+// ErrQuotaExceeded is returned if the key's daily quota would be exceeded.
+func Consume(exec boil.Executor, key *Key, now time.Time, n int) error {
+	if key.DailyQuota > 0 && int64(n) > key.DailyQuota {
+		return ErrQuotaExceeded
+	}
+	var events int64
+	err := exec.QueryRow(`INSERT INTO api_key_usage (key_id, day, events) VALUES ($1, $2::date, $3)
+ON CONFLICT (key_id, day) DO UPDATE SET events = api_key_usage.events + EXCLUDED.events
+WHERE $4 = 0 OR api_key_usage.events + EXCLUDED.events <= $4
+RETURNING events`, key.ID, Day(now), n, key.DailyQuota).Scan(&events)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrQuotaExceeded
+	}
+	return pkgerr.Wrap(err, "update api key usage")
+}
+
+// Charge counts n events of the key on the day of now regardless of its quota, e.g., when
+// these were written while the quota couldn't be checked.
+func Charge(exec boil.Executor, key *Key, now time.Time, n int) error {
+	_, err := exec.Exec(`INSERT INTO api_key_usage (key_id, day, events) VALUES ($1, $2::date, $3)
+ON CONFLICT (key_id, day) DO UPDATE SET events = api_key_usage.events + EXCLUDED.events`, key.ID, Day(now), n)
+	return pkgerr.Wrap(err, "charge api key usage")
+}
+
+// Refund uncounts n events of the key on the day of now, e.g., when they failed to be written.
+func Refund(exec boil.Executor, key *Key, now time.Time, n int) error {
+	_, err := exec.Exec("UPDATE api_key_usage SET events = GREATEST(events - $3, 0) WHERE key_id = $1 AND day = $2::date",
+		key.ID, Day(now), n)
+	return pkgerr.Wrap(err, "refund api key usage")
+}
+
+// Usage returns the events counted for the key on the day of t.
+func Usage(exec boil.Executor, id string, t time.Time) (int64, error) {
+	var events int64
+	err := exec.QueryRow("SELECT events FROM api_key_usage WHERE key_id = $1 AND day = $2::date", id, Day(t)).Scan(&events)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return events, pkgerr.Wrap(err, "select api key usage")
+}
@@ -0,0 +1,84 @@
+package apikey
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Bounds the memory of lookups of made up ids, the cache is reset when full.
+const MAX_CACHED_KEYS = 10000
+
+var ErrInactive = errors.New("API key is revoked or expired")
+
+type cachedKey struct {
+	key      *Key
+	loadedAt time.Time
+}
+
+// Verifier checks tokens against the stored keys. Keys, and unknown ids, are cached
+// for ttl so revocations take up to ttl to apply. Cached keys are used past ttl while
+// lookups fail with transient errors, e.g., when the DB is down.
+type Verifier struct {
+	exec  boil.Executor
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+func NewVerifier(exec boil.Executor, ttl time.Duration) *Verifier {
+	return &Verifier{
+		exec:  exec,
+		ttl:   ttl,
+		cache: map[string]cachedKey{},
+	}
+}
+
+// Verify returns the active key of the token.
+func (v *Verifier) Verify(token string) (*Key, error) {
+	id, secret, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.key(id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || !key.Matches(secret) {
+		return nil, ErrInvalidToken
+	}
+	if !key.Active(time.Now()) {
+		return nil, ErrInactive
+	}
+	return key, nil
+}
+
+// Returns the cached key of the id, nil when there's no such key.
+func (v *Verifier) key(id string) (*Key, error) {
+	now := time.Now()
+	v.mu.Lock()
+	cached, ok := v.cache[id]
+	v.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < v.ttl {
+		return cached.key, nil
+	}
+
+	key, err := Find(v.exec, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		if ok && sqlutil.IsTransient(err) {
+			return cached.key, nil
+		}
+		return nil, err
+	}
+	v.mu.Lock()
+	if len(v.cache) >= MAX_CACHED_KEYS {
+		v.cache = map[string]cachedKey{}
+	}
+	v.cache[id] = cachedKey{key: key, loadedAt: now}
+	v.mu.Unlock()
+	return key, nil
+}
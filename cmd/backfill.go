@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/Bnei-Baruch/chronicles/ingest"
+)
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Fill columns added to entries for historical rows",
+}
+
+var backfillUserAgentsCmd = &cobra.Command{
+	Use:   "user-agents",
+	Short: "Parse user_agent of entries into ua_browser, ua_os, ua_device and ua_bot",
+	Args:  cobra.NoArgs,
+	Run:   backfillUserAgentsFn,
+}
+
+var (
+	backfillFrom      string
+	backfillTo        string
+	backfillAfter     string
+	backfillBatchSize int
+)
+
+func init() {
+	backfillUserAgentsCmd.Flags().StringVar(&backfillFrom, "from", "", "Backfill entries created since (RFC3339), all when empty")
+	backfillUserAgentsCmd.Flags().StringVar(&backfillTo, "to", "", "Backfill entries created before (RFC3339), all when empty")
+	backfillUserAgentsCmd.Flags().StringVar(&backfillAfter, "after", "", "Resume after the last logged id, overrides --from")
+	backfillUserAgentsCmd.Flags().IntVar(&backfillBatchSize, "batch-size", 10000, "Entries scanned per transaction")
+
+	backfillCmd.AddCommand(backfillUserAgentsCmd)
+	rootCmd.AddCommand(backfillCmd)
+}
+
+func backfillUserAgentsFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	start := time.Now()
+	from, to := parseTimeFlag("from", backfillFrom), parseTimeFlag("to", backfillTo)
+	n, err := ingest.Backfill(db, log.Logger, backfillAfter, from, to, backfillBatchSize)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Backfill failed after %d entries", n)
+	}
+	log.Info().Msgf("Backfilled user agents of %d entries in %s", n, time.Since(start))
+}
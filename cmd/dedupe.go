@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/Bnei-Baruch/chronicles/ingest"
+)
+
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Delete entries repeating the (namespace, client_event_id) of an earlier one",
+	Long: `Delete entries repeating the (namespace, client_event_id) of an earlier one, keeping the earliest.
+Run it before migrating to namespace_client_event_id_unique_index, deleted entries can't be restored.`,
+	Args: cobra.NoArgs,
+	Run:  dedupeFn,
+}
+
+var (
+	dedupeAfter     string
+	dedupeBatchSize int
+	dedupeDryRun    bool
+)
+
+func init() {
+	dedupeCmd.Flags().StringVar(&dedupeAfter, "after", "", "Resume after the last logged id")
+	dedupeCmd.Flags().IntVar(&dedupeBatchSize, "batch-size", 50000, "Entries scanned per transaction")
+	dedupeCmd.Flags().BoolVar(&dedupeDryRun, "dry-run", false, "Count duplicates without deleting them")
+	rootCmd.AddCommand(dedupeCmd)
+}
+
+func dedupeFn(cmd *cobra.Command, args []string) {
+	if dedupeBatchSize <= 0 {
+		log.Fatal().Msg("Invalid --batch-size, expected a positive number")
+	}
+	db := openDB()
+	defer db.Close()
+
+	start := time.Now()
+	n, err := ingest.Dedupe(db, log.Logger, dedupeAfter, dedupeBatchSize, dedupeDryRun)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Dedupe failed after %d duplicates", n)
+	}
+	if dedupeDryRun {
+		log.Info().Msgf("Found %d duplicate entries in %s", n, time.Since(start))
+		return
+	}
+	log.Info().Msgf("Deleted %d duplicate entries in %s", n, time.Since(start))
+}
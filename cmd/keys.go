@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/Bnei-Baruch/chronicles/apikey"
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage ingestion API keys",
+}
+
+var keysCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a key, its token is printed once",
+	Args:  cobra.NoArgs,
+	Run:   keysCreateFn,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List keys with today's usage",
+	Args:  cobra.NoArgs,
+	Run:   keysListFn,
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a key",
+	Args:  cobra.ExactArgs(1),
+	Run:   keysRevokeFn,
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate <id>",
+	Short: "Replace a key with a new one of the same scope, the old key expires after the grace period",
+	Args:  cobra.ExactArgs(1),
+	Run:   keysRotateFn,
+}
+
+var (
+	keyName       string
+	keyNamespaces []string
+	keyEventTypes []string
+	keyDailyQuota int64
+	keyGrace      time.Duration
+)
+
+func init() {
+	keysCreateCmd.Flags().StringVar(&keyName, "name", "", "Name of the key, e.g., the client it's given to")
+	keysCreateCmd.MarkFlagRequired("name")
+	keysCreateCmd.Flags().StringSliceVar(&keyNamespaces, "namespaces", nil, "Namespace prefixes the key writes to, all when empty")
+	keysCreateCmd.Flags().StringSliceVar(&keyEventTypes, "event-types", nil, "Client event types the key writes, all when empty")
+	keysCreateCmd.Flags().Int64Var(&keyDailyQuota, "daily-quota", 0, "Events per UTC day, 0 is unlimited")
+	keysRotateCmd.Flags().DurationVar(&keyGrace, "grace", 24*time.Hour, "Period the old key keeps working")
+
+	keysCmd.AddCommand(keysCreateCmd, keysListCmd, keysRevokeCmd, keysRotateCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+func openDB() *sql.DB {
+	db, err := sql.Open("postgres", common.Config.DBUrl)
+	if err != nil {
+		log.Fatal().Err(err).Msg("sql.Open")
+	}
+	return db
+}
+
+func keysCreateFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	key, token, err := apikey.Create(db, keyName, keyNamespaces, keyEventTypes, keyDailyQuota)
+	if err != nil {
+		log.Fatal().Err(err).Msg("apikey.Create")
+	}
+	fmt.Printf("Created key %s\n%s\n", key.ID, token)
+}
+
+func keysListFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	keys, err := apikey.List(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("apikey.List")
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tNAMESPACES\tEVENT TYPES\tTODAY\tQUOTA\tSTATUS")
+	for _, key := range keys {
+		usage, err := apikey.Usage(db, key.ID, now)
+		if err != nil {
+			log.Fatal().Err(err).Msg("apikey.Usage")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", key.ID, key.Name,
+			orAll(key.Namespaces), orAll(key.EventTypes), usage, key.DailyQuota, keyStatus(key, now))
+	}
+	w.Flush()
+}
+
+func orAll(s []string) string {
+	if len(s) == 0 {
+		return "*"
+	}
+	return strings.Join(s, ",")
+}
+
+func keyStatus(key *apikey.Key, now time.Time) string {
+	switch {
+	case key.RevokedAt.Valid:
+		return "revoked " + key.RevokedAt.Time.Format(time.RFC3339)
+	case !key.Active(now):
+		return "expired " + key.ExpiresAt.Time.Format(time.RFC3339)
+	case key.ExpiresAt.Valid:
+		return fmt.Sprintf("replaced by %s, expires %s", key.ReplacedBy.String, key.ExpiresAt.Time.Format(time.RFC3339))
+	}
+	return "active"
+}
+
+func keysRevokeFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	if err := apikey.Revoke(db, args[0]); err != nil {
+		log.Fatal().Err(err).Msg("apikey.Revoke")
+	}
+	fmt.Printf("Revoked key %s\n", args[0])
+}
+
+func keysRotateFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	var key *apikey.Key
+	var token string
+	err := sqlutil.InTx(db, log.Logger, func(tx *sql.Tx) error {
+		var err error
+		key, token, err = apikey.Rotate(tx, args[0], keyGrace)
+		return err
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("apikey.Rotate")
+	}
+	fmt.Printf("Key %s is replaced by %s, expiring in %s\n%s\n", args[0], key.ID, keyGrace, token)
+}
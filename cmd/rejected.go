@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/api"
+	"github.com/Bnei-Baruch/chronicles/ingest"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/schema"
+)
+
+var rejectedCmd = &cobra.Command{
+	Use:   "rejected",
+	Short: "Manage appends rejected by validation",
+}
+
+var rejectedReplayCmd = &cobra.Command{
+	Use:   "replay [id...]",
+	Short: "Validate rejected appends again and write the valid ones, as created when rejected",
+	Run:   rejectedReplayFn,
+}
+
+var (
+	rejectedNamespaces []string
+	rejectedEventTypes []string
+	rejectedFrom       string
+	rejectedTo         string
+	rejectedDryRun     bool
+)
+
+func init() {
+	rejectedReplayCmd.Flags().StringSliceVar(&rejectedNamespaces, "namespaces", nil, "Namespaces to replay, all when empty")
+	rejectedReplayCmd.Flags().StringSliceVar(&rejectedEventTypes, "event-types", nil, "Client event types to replay, all when empty")
+	rejectedReplayCmd.Flags().StringVar(&rejectedFrom, "from", "", "Replay entries rejected since (RFC3339)")
+	rejectedReplayCmd.Flags().StringVar(&rejectedTo, "to", "", "Replay entries rejected before (RFC3339)")
+	rejectedReplayCmd.Flags().BoolVar(&rejectedDryRun, "dry-run", false, "Only report which entries would be replayed")
+
+	rejectedCmd.AddCommand(rejectedReplayCmd)
+	rootCmd.AddCommand(rejectedCmd)
+}
+
+func parseTimeFlag(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Invalid --%s", name)
+	}
+	return t
+}
+
+func rejectedReplayFn(cmd *cobra.Command, args []string) {
+	db := openDB()
+	defer db.Close()
+
+	// Schemas are read once, no need to cache them for long.
+	schemas := schema.NewRegistry(db, time.Minute)
+	replay := func(e *rejected.Entry) error {
+		entries, err := api.ReplayRejected(db, schemas, e)
+		if err != nil || rejectedDryRun {
+			return err
+		}
+		return sqlutil.InTx(db, log.Logger, func(tx *sql.Tx) error {
+			if err := ingest.InsertEntries(tx, entries); err != nil {
+				return err
+			}
+			return rejected.MarkReplayed(tx, e.ID, time.Now())
+		})
+	}
+
+	replayed, failed := 0, 0
+	report := func(e *rejected.Entry, err error) {
+		if err != nil {
+			failed++
+			log.Warn().Err(err).Msgf("Not replaying %s", e.ID)
+			return
+		}
+		replayed++
+	}
+
+	if len(args) > 0 {
+		for _, id := range args {
+			e, err := rejected.Find(db, id)
+			if err != nil {
+				log.Fatal().Err(err).Msgf("rejected.Find %s", id)
+			}
+			report(e, replay(e))
+		}
+	} else {
+		filter := rejected.Filter{
+			Namespaces:       rejectedNamespaces,
+			ClientEventTypes: rejectedEventTypes,
+			From:             parseTimeFlag("from", rejectedFrom),
+			To:               parseTimeFlag("to", rejectedTo),
+			Replayed:         null.BoolFrom(false),
+			Limit:            rejected.MAX_LIMIT,
+		}
+		for {
+			entries, err := rejected.Scan(db, filter)
+			if err != nil {
+				log.Fatal().Err(err).Msg("rejected.Scan")
+			}
+			for _, e := range entries {
+				report(e, replay(e))
+			}
+			if len(entries) < filter.Limit {
+				break
+			}
+			filter.After = entries[len(entries)-1].ID
+		}
+	}
+
+	verb := "Replayed"
+	if rejectedDryRun {
+		verb = "Would replay"
+	}
+	fmt.Printf("%s %d rejected entries, %d still failing\n", verb, replayed, failed)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+var rollupCmd = &cobra.Command{
+	Use:   "rollup",
+	Short: "Update daily_rollups and first_seen until caught up",
+	Run:   rollupFn,
+}
+
+func init() {
+	rootCmd.AddCommand(rollupCmd)
+}
+
+func rollupFn(cmd *cobra.Command, args []string) {
+	db, err := sql.Open("postgres", common.Config.DBUrl)
+	if err != nil {
+		log.Fatal().Err(err).Msg("sql.Open")
+	}
+	defer db.Close()
+
+	for _, job := range rollup.Jobs {
+		start := time.Now()
+		n, err := job.Run(db, log.Logger, common.Config.RollupBatchSize, common.Config.RollupLag)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Job %s failed after %d entries", job.Name, n)
+		}
+		log.Info().Msgf("Job %s processed %d entries in %s", job.Name, n, time.Since(start))
+	}
+}
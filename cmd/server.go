@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,11 +15,21 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
+	"github.com/segmentio/ksuid"
 	"github.com/spf13/cobra"
 
 	"github.com/Bnei-Baruch/chronicles/api"
+	"github.com/Bnei-Baruch/chronicles/apikey"
 	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/ingest"
 	"github.com/Bnei-Baruch/chronicles/middleware"
+	"github.com/Bnei-Baruch/chronicles/pkg/jwks"
+	"github.com/Bnei-Baruch/chronicles/pkg/spool"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+	"github.com/Bnei-Baruch/chronicles/schema"
+	"github.com/Bnei-Baruch/chronicles/tail"
 	"github.com/Bnei-Baruch/chronicles/version"
 )
 
@@ -34,7 +46,41 @@ func init() {
 func serverFn(cmd *cobra.Command, args []string) {
 	log.Info().Msgf("Starting Chronicles server version %s", version.Version)
 
-	log.Debug().Msgf("Config\n%v", common.Config)
+	log.Debug().Msgf("Config\n%v", common.Config.Redacted())
+
+	if common.Config.CursorSecret == "" {
+		log.Warn().Msg("CURSOR_SECRET is not set, scan cursors won't be valid across restarts and replicas")
+		common.Config.CursorSecret = randomSecret()
+	}
+
+	var authenticator *middleware.Authenticator
+	if common.Config.KeycloakJWKS != "" {
+		keys, err := jwks.Load(common.Config.KeycloakJWKS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("jwks.Load")
+		}
+		if common.Config.KeycloakClientID == "" {
+			log.Warn().Msg("KEYCLOAK_CLIENT_ID is not set, tokens issued to any client are accepted")
+		}
+		authenticator = middleware.NewAuthenticator(keys, common.Config.KeycloakIssuer, common.Config.KeycloakClientID)
+	} else if common.Config.SkipAuth {
+		log.Warn().Msg("SKIP_AUTH is set, all requests are allowed")
+	} else if common.Config.RequireAuth {
+		log.Fatal().Msg("KEYCLOAK_JWKS is required by REQUIRE_AUTH")
+	} else {
+		// Like versions before tokens were verified.
+		log.Warn().Msg("KEYCLOAK_JWKS is not set, tokens are not verified and all requests are allowed as with SKIP_AUTH")
+		common.Config.SkipAuth = true
+	}
+
+	policies, err := middleware.ParsePolicies(common.Config.RolePolicies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("middleware.ParsePolicies")
+	}
+	proxies, err := middleware.ParseTrustedProxies(common.Config.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("middleware.ParseTrustedProxies")
+	}
 
 	db, err := sql.Open("postgres", common.Config.DBUrl)
 	if err != nil {
@@ -43,17 +89,93 @@ func serverFn(cmd *cobra.Command, args []string) {
 	defer db.Close()
 	// boil.DebugMode = true
 
+	// Also dead letters of ingestion.
+	var rejections *rejected.Recorder
+	if common.Config.RejectedTTL > 0 {
+		rejections = rejected.NewRecorder(db, common.Config.RejectedTTL, log.Logger)
+		rejections.Start()
+	}
+
+	var spooler *ingest.Spooler
+	if common.Config.SpoolDir != "" {
+		sp, err := spool.Open(common.Config.SpoolDir, common.Config.SpoolSegmentSize)
+		if err != nil {
+			log.Fatal().Err(err).Msg("spool.Open")
+		}
+		defer sp.Close()
+		spooler = ingest.NewSpooler(db, sp, rejections, common.Config.SpoolReplayInterval)
+		spooler.Start()
+	}
+
+	var writer ingest.Writer = ingest.NewSyncWriter(db, spooler)
+	var pipeline *ingest.Pipeline
+	if common.Config.IngestAsync {
+		pipeline = ingest.NewPipeline(db, spooler, rejections, ingest.PipelineConfig{
+			QueueSize:     common.Config.IngestQueueSize,
+			Workers:       common.Config.IngestWorkers,
+			BatchSize:     common.Config.IngestBatchSize,
+			FlushInterval: common.Config.IngestFlushInterval,
+		})
+		pipeline.Start()
+		writer = pipeline
+	}
+
+	hub := tail.NewHub()
+	var notifier *tail.Notifier
+	var listener *tail.Listener
+	if common.Config.TailNotify {
+		// Identifies this replica's notifications.
+		origin := ksuid.New().String()
+		notifier = tail.NewNotifier(db, origin)
+		notifier.Start()
+		listener, err = tail.Listen(common.Config.DBUrl, hub, origin)
+		if err != nil {
+			log.Fatal().Err(err).Msg("tail.Listen")
+		}
+	}
+	writer = tail.NewWriter(writer, hub, notifier)
+
+	limits, err := ratelimit.ParseConfig(common.Config.RateLimits)
+	if err != nil {
+		log.Fatal().Err(err).Msg("ratelimit.ParseConfig")
+	}
+	var store ratelimit.Store
+	switch common.Config.RateLimitStore {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(db)
+	default:
+		log.Fatal().Msgf("Unknown RATE_LIMIT_STORE %q, expected memory or postgres", common.Config.RateLimitStore)
+	}
+	limiter := ratelimit.NewLimiter(store, limits, log.Logger)
+
+	var scheduler *rollup.Scheduler
+	if common.Config.RollupInterval > 0 {
+		scheduler = rollup.NewScheduler(db, common.Config.RollupInterval, common.Config.RollupBatchSize, common.Config.RollupLag)
+		scheduler.Start()
+	}
+
 	// Setup gin
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AddAllowHeaders("Authorization", middleware.API_KEY_HEADER)
+
 	gin.SetMode(common.Config.GinServerMode)
 	router := gin.New()
+	// Client IPs are taken from X-Forwarded-For of trusted proxies only, see RealIPMiddleware.
+	router.ForwardedByClientIP = false
 	router.Use(
+		middleware.RealIPMiddleware(proxies),
 		middleware.LoggingMiddleware(),
 		middleware.RecoveryMiddleware(),
 		middleware.ErrorHandlingMiddleware(),
-		cors.Default(),
-		middleware.ContextMiddleware(db))
+		cors.New(corsConfig),
+		middleware.AuthenticationMiddleware(authenticator),
+		middleware.RoleMiddleware(common.Config.SkipAuth, policies),
+		middleware.ContextMiddleware(db, writer, hub, limiter, schema.NewRegistry(db, common.Config.SchemaCacheTTL), rejections))
 
-	api.SetupRoutes(router)
+	api.SetupRoutes(router, apikey.NewVerifier(db, common.Config.APIKeyCacheTTL))
 
 	addr := common.Config.ListenAddress
 	log.Info().Msgf("Running application %s", addr)
@@ -61,6 +183,8 @@ func serverFn(cmd *cobra.Command, args []string) {
 		Addr:    addr,
 		Handler: router,
 	}
+	// Live tails would otherwise keep the server from shutting down.
+	srv.RegisterOnShutdown(hub.Close)
 
 	// Initializing the server in a goroutine so that
 	// it won't block the graceful shutdown handling below
@@ -71,7 +195,7 @@ func serverFn(cmd *cobra.Command, args []string) {
 	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server with a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Info().Msg("Shutting down server...")
@@ -81,8 +205,41 @@ func serverFn(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Server forced to shutdown")
+		log.Error().Err(err).Msg("Server forced to shutdown")
+	}
+
+	// No more requests are handled, write everything accepted so far.
+	if pipeline != nil {
+		log.Info().Msg("Draining ingest pipeline...")
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), common.Config.IngestDrainTimeout)
+		defer drainCancel()
+		if err := pipeline.Drain(drainCtx); err != nil {
+			log.Error().Err(err).Msg("Ingest pipeline drain")
+		}
+	}
+	if spooler != nil {
+		spooler.Stop()
+	}
+	if scheduler != nil {
+		scheduler.Stop()
+	}
+	if rejections != nil {
+		rejections.Stop()
+	}
+	if listener != nil {
+		listener.Stop()
+	}
+	if notifier != nil {
+		notifier.Stop()
 	}
 
 	log.Info().Msg("Server exiting")
 }
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal().Err(err).Msg("rand.Read")
+	}
+	return hex.EncodeToString(b)
+}
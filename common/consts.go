@@ -1 +1,8 @@
 package common
+
+const (
+	// Prefix of user ids of entries appended with client_id instead of keycloak_id.
+	CLIENT_USER_ID_PREFIX = "client:"
+	// Clients not logged in.
+	INCOGNITO_USER_ID_PREFIX = CLIENT_USER_ID_PREFIX + "local:"
+)
@@ -6,9 +6,13 @@ require (
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/golang-jwt/jwt/v4 v4.5.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/lib/pq v1.8.0
+	github.com/mssola/user_agent v0.6.0
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.19.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/segmentio/ksuid v1.0.3
 	github.com/spf13/cobra v1.2.1
 	github.com/stretchr/testify v1.7.0
@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"database/sql"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Entries of a batch repeating the (namespace, client_event_id) of an earlier entry.
+const duplicatesPredicate = `a.id > $1 AND a.id <= $2 AND a.client_event_id IS NOT NULL
+AND EXISTS (SELECT 1 FROM entries b WHERE b.namespace = a.namespace AND b.client_event_id = a.client_event_id AND b.id < a.id)`
+
+// DedupeBatch deletes entries with ids greater than after, scanning at most batchSize entries,
+// that repeat the (namespace, client_event_id) of an earlier entry, keeping the earliest one.
+// It returns the last scanned id, empty when there are no more, and the number of duplicates,
+// only counted when dryRun.
+func DedupeBatch(db *sql.DB, log zerolog.Logger, after string, batchSize int, dryRun bool) (string, int64, error) {
+	var lastID sql.NullString
+	var duplicates int64
+	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT MAX(id) FROM (SELECT id FROM entries WHERE id > $1 ORDER BY id LIMIT $2) b",
+			after, batchSize).Scan(&lastID)
+		if err != nil {
+			return pkgerr.Wrap(err, "select batch")
+		}
+		if !lastID.Valid {
+			return nil
+		}
+
+		if dryRun {
+			err := tx.QueryRow("SELECT COUNT(*) FROM entries a WHERE "+duplicatesPredicate, after, lastID.String).Scan(&duplicates)
+			return pkgerr.Wrap(err, "count duplicates")
+		}
+		res, err := tx.Exec("DELETE FROM entries a WHERE "+duplicatesPredicate, after, lastID.String)
+		if err != nil {
+			return pkgerr.Wrap(err, "delete duplicates")
+		}
+		duplicates, err = res.RowsAffected()
+		return err
+	})
+	return lastID.String, duplicates, err
+}
+
+// Dedupe deletes duplicates of all entries with ids greater than after, in batches of KSUID
+// ranges. Progress is logged with the last id, pass it as after to resume.
+func Dedupe(db *sql.DB, log zerolog.Logger, after string, batchSize int, dryRun bool) (int64, error) {
+	var total int64
+	for {
+		lastID, duplicates, err := DedupeBatch(db, log, after, batchSize, dryRun)
+		total += duplicates
+		if err != nil || lastID == "" {
+			return total, err
+		}
+		log.Info().Msgf("Found %d duplicate entries until %s", total, lastID)
+		after = lastID
+	}
+}
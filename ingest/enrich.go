@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/ksuidutil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/pkg/useragent"
+)
+
+// Length of the ua_browser and ua_os columns.
+const MAX_UA_NAME_LENGTH = 64
+
+// Enrich sets the columns derived from the entry's user agent.
+func Enrich(e *models.Entry) {
+	info := useragent.Parse(e.UserAgent)
+	e.UaBrowser = uaName(info.Browser)
+	e.UaOs = uaName(info.OS)
+	e.UaDevice = null.StringFrom(info.Device)
+	e.UaBot = null.BoolFrom(info.Bot)
+}
+
+func uaName(name string) null.String {
+	if len(name) > MAX_UA_NAME_LENGTH {
+		name = name[:MAX_UA_NAME_LENGTH]
+	}
+	return null.NewString(name, name != "")
+}
+
+// Sets the user agent columns of entries in the id range, not enriched yet, per distinct user agent.
+const backfillQuery = `UPDATE entries AS e
+SET ua_browser = NULLIF(v.browser, ''), ua_os = NULLIF(v.os, ''), ua_device = v.device, ua_bot = v.bot
+FROM unnest($3::text[], $4::text[], $5::text[], $6::text[], $7::boolean[]) AS v (user_agent, browser, os, device, bot)
+WHERE e.id >= $1 AND e.id <= $2 AND e.ua_device IS NULL AND e.user_agent = v.user_agent`
+
+// BackfillBatch enriches entries not enriched yet with ids greater than after, and less than
+// before unless empty, scanning at most batchSize entries. It returns the last scanned id,
+// empty when there are no more, and the number of entries updated.
+func BackfillBatch(db *sql.DB, log zerolog.Logger, after, before string, batchSize int) (string, int64, error) {
+	var lastID string
+	var updated int64
+	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		mods := []qm.QueryMod{
+			qm.Select(models.EntryColumns.ID, models.EntryColumns.UserAgent, models.EntryColumns.UaDevice),
+			models.EntryWhere.ID.GT(after),
+			qm.OrderBy(models.EntryColumns.ID),
+			qm.Limit(batchSize),
+		}
+		if before != "" {
+			mods = append(mods, models.EntryWhere.ID.LT(before))
+		}
+		entries, err := models.Entries(mods...).All(tx)
+		if err != nil {
+			return pkgerr.Wrap(err, "select entries")
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		lastID = entries[len(entries)-1].ID
+
+		var agents, browsers, oses, devices []string
+		var bots []bool
+		seen := map[string]bool{}
+		for _, e := range entries {
+			if e.UaDevice.Valid || seen[e.UserAgent] {
+				continue
+			}
+			seen[e.UserAgent] = true
+			Enrich(e)
+			agents = append(agents, e.UserAgent)
+			browsers = append(browsers, e.UaBrowser.String)
+			oses = append(oses, e.UaOs.String)
+			devices = append(devices, e.UaDevice.String)
+			bots = append(bots, e.UaBot.Bool)
+		}
+		if len(agents) == 0 {
+			return nil
+		}
+
+		res, err := tx.Exec(backfillQuery, entries[0].ID, lastID,
+			pq.Array(agents), pq.Array(browsers), pq.Array(oses), pq.Array(devices), pq.Array(bots))
+		if err != nil {
+			return pkgerr.Wrap(err, "update entries")
+		}
+		updated, err = res.RowsAffected()
+		return err
+	})
+	return lastID, updated, err
+}
+
+// Backfill enriches entries created in [from, to), zero times leaving the range open,
+// in batches of KSUID ranges. Progress is logged with the last id, pass it as after to resume.
+func Backfill(db *sql.DB, log zerolog.Logger, after string, from, to time.Time, batchSize int) (int64, error) {
+	if after == "" && !from.IsZero() {
+		after = ksuidutil.LowerBound(from)
+	}
+	before := ""
+	if !to.IsZero() {
+		before = ksuidutil.LowerBound(to)
+	}
+
+	var total int64
+	for {
+		lastID, updated, err := BackfillBatch(db, log, after, before, batchSize)
+		total += updated
+		if err != nil || lastID == "" {
+			return total, err
+		}
+		log.Info().Msgf("Backfilled user agents of %d entries until %s", total, lastID)
+		after = lastID
+	}
+}
@@ -0,0 +1,39 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/useragent"
+)
+
+type EnrichSuite struct {
+	suite.Suite
+}
+
+func TestEnrich(t *testing.T) {
+	suite.Run(t, new(EnrichSuite))
+}
+
+func (suite *EnrichSuite) TestEnrich() {
+	e := &models.Entry{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"}
+	Enrich(e)
+	suite.Equal(null.StringFrom("Safari"), e.UaBrowser)
+	suite.Equal(null.StringFrom("iOS"), e.UaOs)
+	suite.Equal(null.StringFrom(useragent.DEVICE_MOBILE), e.UaDevice)
+	suite.Equal(null.BoolFrom(false), e.UaBot)
+
+	// Unknown names are NULL, the device is always set.
+	e = &models.Entry{}
+	Enrich(e)
+	suite.False(e.UaBrowser.Valid)
+	suite.False(e.UaOs.Valid)
+	suite.Equal(null.StringFrom(useragent.DEVICE_OTHER), e.UaDevice)
+	suite.Equal(null.BoolFrom(false), e.UaBot)
+
+	suite.Len(uaName(strings.Repeat("a", 100)).String, MAX_UA_NAME_LENGTH)
+}
@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/rollup"
+)
+
+// Postgres allows at most 65535 bind parameters per statement.
+// With 16 columns per entry 1000 rows per statement is well below that.
+const INSERT_CHUNK_SIZE = 1000
+
+var insertColumns = []string{
+	models.EntryColumns.ID,
+	models.EntryColumns.CreatedAt,
+	models.EntryColumns.UserID,
+	models.EntryColumns.IPAddr,
+	models.EntryColumns.UserAgent,
+	models.EntryColumns.Namespace,
+	models.EntryColumns.ClientEventID,
+	models.EntryColumns.ClientEventType,
+	models.EntryColumns.ClientFlowID,
+	models.EntryColumns.ClientFlowType,
+	models.EntryColumns.ClientSessionID,
+	models.EntryColumns.Data,
+	models.EntryColumns.UaBrowser,
+	models.EntryColumns.UaOs,
+	models.EntryColumns.UaDevice,
+	models.EntryColumns.UaBot,
+}
+
+func entryValues(e *models.Entry) []interface{} {
+	return []interface{}{
+		e.ID,
+		e.CreatedAt,
+		e.UserID,
+		e.IPAddr,
+		e.UserAgent,
+		e.Namespace,
+		e.ClientEventID,
+		e.ClientEventType,
+		e.ClientFlowID,
+		e.ClientFlowType,
+		e.ClientSessionID,
+		e.Data,
+		e.UaBrowser,
+		e.UaOs,
+		e.UaDevice,
+		e.UaBot,
+	}
+}
+
+const (
+	// Duplicates on (namespace, client_event_id) are resolved by resolveDuplicates, the target
+	// matches the partial namespace_client_event_id_unique_index so that other conflicts fail.
+	ON_CONFLICT_DUPLICATE = " ON CONFLICT (\"namespace\",\"client_event_id\") WHERE client_event_id IS NOT NULL DO NOTHING"
+	// Replays also skip entries stored by a previous replay of the same entries.
+	ON_CONFLICT_ANY = " ON CONFLICT DO NOTHING"
+)
+
+// Builds a multi-row INSERT statement for n entries.
+func insertQuery(n int, onConflict string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO \"entries\" (\"%s\") VALUES ", strings.Join(insertColumns, "\",\""))
+	param := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('(')
+		for j := range insertColumns {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "$%d", param)
+			param++
+		}
+		b.WriteByte(')')
+	}
+	b.WriteString(onConflict)
+	b.WriteString(" RETURNING \"id\"")
+	return b.String()
+}
+
+func idempotencyKey(namespace, clientEventID string) string {
+	return namespace + "\x00" + clientEventID
+}
+
+// InsertEntries writes all entries using multi-row INSERT statements.
+// Callers should pass a transaction to have the entries written atomically.
+// Entries repeating an already stored (namespace, client_event_id) are not
+// inserted, instead their ID is replaced with the originally assigned one.
+// Inserted entries with old ids or created_at are queued for rollups (see rollup.EnqueueLate).
+func InsertEntries(exec boil.Executor, entries []*models.Entry) error {
+	return insertEntries(exec, entries, ON_CONFLICT_DUPLICATE, true)
+}
+
+// ReplayEntries writes entries as InsertEntries, skipping entries already stored, with the same
+// id or (namespace, client_event_id), without replacing their IDs. Replaying is idempotent.
+func ReplayEntries(exec boil.Executor, entries []*models.Entry) error {
+	return insertEntries(exec, entries, ON_CONFLICT_ANY, false)
+}
+
+func insertEntries(exec boil.Executor, entries []*models.Entry, onConflict string, resolve bool) error {
+	written := make([]*models.Entry, 0, len(entries))
+	for start := 0; start < len(entries); start += INSERT_CHUNK_SIZE {
+		end := start + INSERT_CHUNK_SIZE
+		if end > len(entries) {
+			end = len(entries)
+		}
+		chunk := entries[start:end]
+		args := make([]interface{}, 0, len(chunk)*len(insertColumns))
+		for _, e := range chunk {
+			args = append(args, entryValues(e)...)
+		}
+
+		rows, err := exec.Query(insertQuery(len(chunk), onConflict), args...)
+		if err != nil {
+			return pkgerr.Wrap(err, "insert entries")
+		}
+		byID := make(map[string]*models.Entry, len(chunk))
+		for _, e := range chunk {
+			byID[e.ID] = e
+		}
+		inserted := make(map[string]bool, len(chunk))
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return pkgerr.Wrap(err, "scan inserted id")
+			}
+			inserted[id] = true
+			written = append(written, byID[id])
+		}
+		if err := rows.Close(); err != nil {
+			return pkgerr.Wrap(err, "close inserted ids")
+		}
+		if err := rows.Err(); err != nil {
+			return pkgerr.Wrap(err, "iterate inserted ids")
+		}
+
+		if resolve && len(inserted) < len(chunk) {
+			duplicates := []*models.Entry{}
+			for _, e := range chunk {
+				if !inserted[e.ID] {
+					duplicates = append(duplicates, e)
+				}
+			}
+			if _, err := resolveDuplicates(exec, duplicates); err != nil {
+				return err
+			}
+		}
+	}
+	return rollup.EnqueueLate(exec, written, time.Now())
+}
+
+// Replaces the ID of every entry with the ID of the stored entry having the
+// same (namespace, client_event_id). Returns the entries that were replaced.
+func resolveDuplicates(exec boil.Executor, entries []*models.Entry) (map[*models.Entry]bool, error) {
+	var b strings.Builder
+	b.WriteString("SELECT \"id\", \"namespace\", \"client_event_id\" FROM \"entries\" WHERE client_event_id IS NOT NULL AND (namespace, client_event_id) IN (")
+	args := []interface{}(nil)
+	for _, e := range entries {
+		if !e.ClientEventID.Valid {
+			continue
+		}
+		if len(args) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "($%d,$%d)", len(args)+1, len(args)+2)
+		args = append(args, e.Namespace, e.ClientEventID.String)
+	}
+	b.WriteByte(')')
+	replaced := map[*models.Entry]bool{}
+	if len(args) == 0 {
+		return replaced, nil
+	}
+
+	rows, err := exec.Query(b.String(), args...)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "select duplicate entries")
+	}
+	defer rows.Close()
+	stored := make(map[string]string)
+	for rows.Next() {
+		var id, namespace, clientEventID string
+		if err := rows.Scan(&id, &namespace, &clientEventID); err != nil {
+			return nil, pkgerr.Wrap(err, "scan duplicate entry")
+		}
+		stored[idempotencyKey(namespace, clientEventID)] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pkgerr.Wrap(err, "iterate duplicate entries")
+	}
+
+	for _, e := range entries {
+		if !e.ClientEventID.Valid {
+			continue
+		}
+		if id, ok := stored[idempotencyKey(e.Namespace, e.ClientEventID.String)]; ok {
+			e.ID = id
+			replaced[e] = true
+		}
+	}
+	return replaced, nil
+}
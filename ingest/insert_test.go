@@ -0,0 +1,26 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type InsertSuite struct {
+	suite.Suite
+}
+
+func TestInsert(t *testing.T) {
+	suite.Run(t, new(InsertSuite))
+}
+
+func (suite *InsertSuite) TestInsertQuery() {
+	q := insertQuery(2, ON_CONFLICT_DUPLICATE)
+	suite.True(strings.HasPrefix(q, "INSERT INTO \"entries\" (\"id\",\"created_at\","))
+	suite.True(strings.HasSuffix(q, "($17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32) ON CONFLICT (\"namespace\",\"client_event_id\") WHERE client_event_id IS NOT NULL DO NOTHING RETURNING \"id\""))
+	suite.Equal(2, strings.Count(q, "("+"$"))
+
+	q = insertQuery(1, ON_CONFLICT_ANY)
+	suite.True(strings.HasSuffix(q, "$16) ON CONFLICT DO NOTHING RETURNING \"id\""))
+}
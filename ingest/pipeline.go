@@ -0,0 +1,259 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+)
+
+const (
+	FLUSH_ATTEMPTS = 5
+	FLUSH_BACKOFF  = 500 * time.Millisecond
+)
+
+var (
+	ErrQueueFull = errors.New("ingest queue is full")
+	ErrClosed    = errors.New("ingest pipeline is closed")
+)
+
+type PipelineConfig struct {
+	QueueSize     int
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Pipeline is an asynchronous Writer. Entries are queued in a bounded
+// in-memory queue and written in batches by a pool of writer goroutines.
+// Entries repeating the (namespace, client_event_id) of a stored entry get its ID
+// and are not queued. Duplicates of entries still queued are dropped when written,
+// their IDs are not replaced since the request has completed by then.
+// Batches failing all flush attempts are spooled when a spooler is set. Batches failing
+// permanently, e.g. on an invalid value, are written entry by entry, the failing entries
+// are dead-lettered to rejections when set.
+type Pipeline struct {
+	db         *sql.DB
+	spooler    *Spooler
+	rejections *rejected.Recorder
+	config     PipelineConfig
+	queue      chan *models.Entry
+	wg         sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewPipeline(db *sql.DB, spooler *Spooler, rejections *rejected.Recorder, config PipelineConfig) *Pipeline {
+	return &Pipeline{
+		db:         db,
+		spooler:    spooler,
+		rejections: rejections,
+		config:     config,
+		queue:      make(chan *models.Entry, config.QueueSize),
+	}
+}
+
+func (p *Pipeline) Start() {
+	for i := 0; i < p.config.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	log.Info().Msgf("Ingest pipeline started with %d workers", p.config.Workers)
+}
+
+// Write queues all entries or none of them.
+// Returns ErrQueueFull when there is not enough room for all entries.
+func (p *Pipeline) Write(log zerolog.Logger, entries []*models.Entry) error {
+	if p.db != nil {
+		entries = p.resolveStored(log, entries)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	// Only writers add to the queue and they hold the lock, so the sends below never block.
+	if len(p.queue)+len(entries) > cap(p.queue) {
+		return ErrQueueFull
+	}
+	for _, entry := range entries {
+		p.queue <- entry
+	}
+	return nil
+}
+
+// Replaces the IDs of entries already stored, returning the other ones. When the lookup
+// fails, e.g. the DB is down, all entries are returned with their new IDs.
+func (p *Pipeline) resolveStored(log zerolog.Logger, entries []*models.Entry) []*models.Entry {
+	replaced, err := resolveDuplicates(p.db, entries)
+	if err != nil {
+		log.Warn().Err(err).Msg("Ingest pipeline failed looking up duplicates")
+		return entries
+	}
+	if len(replaced) == 0 {
+		return entries
+	}
+	queued := make([]*models.Entry, 0, len(entries)-len(replaced))
+	for _, e := range entries {
+		if !replaced[e] {
+			queued = append(queued, e)
+		}
+	}
+	return queued
+}
+
+// Drain stops accepting new entries and waits for all queued entries to be written.
+func (p *Pipeline) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		log.Error().Int("queued", len(p.queue)).Msg("Ingest pipeline drain timed out")
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) worker() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.Entry, 0, p.config.BatchSize)
+	for {
+		select {
+		case entry, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= p.config.BatchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (p *Pipeline) flush(batch []*models.Entry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := p.insert(batch)
+		if err == nil {
+			return
+		}
+		if !sqlutil.IsTransient(err) {
+			log.Warn().Err(err).Int("entries", len(batch)).Msg("Ingest pipeline flush failed, writing entries one by one")
+			p.flushEach(batch)
+			return
+		}
+		if attempt == FLUSH_ATTEMPTS {
+			p.fallback(batch, err)
+			return
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Msg("Ingest pipeline flush failed, retrying")
+		time.Sleep(time.Duration(attempt) * FLUSH_BACKOFF)
+	}
+}
+
+func (p *Pipeline) insert(entries []*models.Entry) error {
+	return sqlutil.InTx(p.db, log.Logger, func(tx *sql.Tx) error {
+		return InsertEntries(tx, entries)
+	})
+}
+
+// Writes entries of a batch failing permanently by themselves, so that only the failing ones
+// are dead-lettered. Falls back with the rest once the DB fails transiently.
+func (p *Pipeline) flushEach(batch []*models.Entry) {
+	for i, entry := range batch {
+		err := p.insert([]*models.Entry{entry})
+		if err == nil {
+			continue
+		}
+		if sqlutil.IsTransient(err) {
+			p.fallback(batch[i:], err)
+			return
+		}
+		recordDeadLetter(p.rejections, entry, err)
+	}
+}
+
+// Keeps an entry failing to be written in rejections, dropping it when nil.
+func recordDeadLetter(rejections *rejected.Recorder, entry *models.Entry, err error) {
+	if rejections == nil {
+		log.Error().Err(err).Str("id", entry.ID).Msg("Dropped entry failing to be written")
+		return
+	}
+	dead, merr := deadLetter(entry, err)
+	if merr != nil {
+		log.Error().Err(merr).Str("id", entry.ID).Msg("Dropped entry failing to be written")
+		return
+	}
+	log.Warn().Err(err).Str("id", entry.ID).Msg("Dead-lettered entry failing to be written")
+	rejections.Record(dead)
+}
+
+// Rejected entry of an entry failing to be written, replayed as is.
+func deadLetter(entry *models.Entry, err error) (*rejected.Entry, error) {
+	body, merr := json.Marshal(entry)
+	if merr != nil {
+		return nil, merr
+	}
+	return &rejected.Entry{
+		CreatedAt:       entry.CreatedAt,
+		Endpoint:        rejected.ENDPOINT_INGEST,
+		Namespace:       entry.Namespace,
+		ClientEventType: entry.ClientEventType,
+		Reason:          err.Error(),
+		Body:            rejected.Body(body),
+		IPAddr:          entry.IPAddr,
+		UserAgent:       entry.UserAgent,
+	}, nil
+}
+
+func (p *Pipeline) fallback(batch []*models.Entry, err error) {
+	// Replaying batches failing permanently would fail forever.
+	if p.spooler != nil && sqlutil.IsTransient(err) {
+		serr := p.spooler.Spool(batch)
+		if serr == nil {
+			log.Warn().Err(err).Int("entries", len(batch)).Msg("Ingest pipeline spooled batch")
+			return
+		}
+		log.Error().Err(serr).Msg("Spool entries")
+	}
+	log.Error().Err(err).Int("entries", len(batch)).Msg("Ingest pipeline dropped batch")
+}
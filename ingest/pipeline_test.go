@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+)
+
+type PipelineSuite struct {
+	suite.Suite
+}
+
+func TestPipeline(t *testing.T) {
+	suite.Run(t, new(PipelineSuite))
+}
+
+func (suite *PipelineSuite) TestBackpressure() {
+	p := NewPipeline(nil, nil, nil, PipelineConfig{QueueSize: 2, Workers: 1, BatchSize: 10, FlushInterval: time.Second})
+
+	suite.Nil(p.Write(log.Logger, []*models.Entry{{ID: "1"}}))
+	suite.Equal(ErrQueueFull, p.Write(log.Logger, []*models.Entry{{ID: "2"}, {ID: "3"}}))
+	suite.Nil(p.Write(log.Logger, []*models.Entry{{ID: "2"}}))
+	suite.Equal(2, len(p.queue))
+
+	// No workers were started so draining only closes the queue.
+	suite.Nil(p.Drain(context.Background()))
+	suite.Equal(ErrClosed, p.Write(log.Logger, []*models.Entry{{ID: "4"}}))
+}
+
+func (suite *PipelineSuite) TestDeadLetter() {
+	entry := &models.Entry{
+		ID:              "2EHqDQdm3ZHTHWw8jGqzjcvBg1P",
+		CreatedAt:       time.Date(2025, 11, 4, 9, 0, 0, 0, time.UTC),
+		IPAddr:          "10.0.0.1",
+		UserAgent:       "curl/8.0",
+		Namespace:       "archive",
+		ClientEventType: "page-view",
+	}
+	e, err := deadLetter(entry, errors.New("unsupported Unicode escape sequence"))
+	suite.Require().Nil(err)
+	suite.Equal(rejected.ENDPOINT_INGEST, e.Endpoint)
+	suite.Equal(entry.CreatedAt, e.CreatedAt)
+	suite.Equal("archive", e.Namespace)
+	suite.Equal("unsupported Unicode escape sequence", e.Reason)
+
+	replayed := &models.Entry{}
+	suite.Require().Nil(json.Unmarshal([]byte(e.Body), replayed))
+	suite.Equal(entry.ID, replayed.ID)
+	suite.True(entry.CreatedAt.Equal(replayed.CreatedAt))
+}
@@ -0,0 +1,168 @@
+package ingest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/spool"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+)
+
+const REPLAY_BATCH_SIZE = 500
+
+// Spooler keeps entries that could not be written to the DB in an on-disk
+// spool and replays them, with their original IDs and created_at, once the
+// DB is reachable again. Entries failing permanently are dead-lettered to rejections when set.
+type Spooler struct {
+	db         *sql.DB
+	spool      *spool.Spool
+	rejections *rejected.Recorder
+	interval   time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSpooler(db *sql.DB, s *spool.Spool, rejections *rejected.Recorder, interval time.Duration) *Spooler {
+	return &Spooler{
+		db:         db,
+		spool:      s,
+		rejections: rejections,
+		interval:   interval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Spool durably stores the entries for a later replay.
+func (s *Spooler) Spool(entries []*models.Entry) error {
+	records := make([][]byte, len(entries))
+	for i, entry := range entries {
+		record, err := json.Marshal(entry)
+		if err != nil {
+			return pkgerr.Wrap(err, "json.Marshal entry")
+		}
+		records[i] = record
+	}
+	return s.spool.Append(records)
+}
+
+// Start runs the replayer in the background until Stop is called.
+func (s *Spooler) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.replay()
+			}
+		}
+	}()
+}
+
+func (s *Spooler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Spooler) replay() {
+	paths, err := s.spool.Rotate()
+	if err != nil {
+		log.Error().Err(err).Msg("Spool rotate")
+		return
+	}
+	if len(paths) == 0 {
+		return
+	}
+	if err := sqlutil.Ping(s.db, time.Second); err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		err := s.replaySegment(path)
+		if err == spool.ErrCorrupt {
+			log.Error().Str("segment", path).Msg("Spool segment is corrupt, quarantined")
+			if err := s.spool.Quarantine(path); err != nil {
+				log.Error().Err(err).Str("segment", path).Msg("Spool quarantine")
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("segment", path).Msg("Spool replay stopped")
+			return
+		}
+		if err := s.spool.Remove(path); err != nil {
+			log.Error().Err(err).Str("segment", path).Msg("Spool remove")
+			return
+		}
+		log.Info().Str("segment", path).Msg("Spool segment replayed")
+	}
+}
+
+// Replaying is idempotent (see ReplayEntries) so a segment that was partially replayed before
+// can be replayed again.
+func (s *Spooler) replaySegment(path string) error {
+	batch := make([]*models.Entry, 0, REPLAY_BATCH_SIZE)
+	err := spool.ReadSegment(path, func(record []byte) error {
+		entry := &models.Entry{}
+		if err := json.Unmarshal(record, entry); err != nil {
+			return spool.ErrCorrupt
+		}
+		batch = append(batch, entry)
+		if len(batch) < REPLAY_BATCH_SIZE {
+			return nil
+		}
+		err := s.insert(batch)
+		batch = batch[:0]
+		return err
+	})
+	// Entries read before a corrupt record are still replayed.
+	if err != nil && err != spool.ErrCorrupt {
+		return err
+	}
+	if ierr := s.insert(batch); ierr != nil {
+		return ierr
+	}
+	return err
+}
+
+func (s *Spooler) insert(batch []*models.Entry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := s.replayEntries(batch)
+	if err == nil || sqlutil.IsTransient(err) {
+		return err
+	}
+
+	// Some entries are rejected. Skip those instead of blocking the spool, but keep the
+	// segment if the DB becomes unreachable meanwhile.
+	for _, entry := range batch {
+		err := s.replayEntries([]*models.Entry{entry})
+		if err == nil {
+			continue
+		}
+		if sqlutil.IsTransient(err) {
+			return err
+		}
+		recordDeadLetter(s.rejections, entry, err)
+	}
+	return nil
+}
+
+func (s *Spooler) replayEntries(entries []*models.Entry) error {
+	return sqlutil.InTx(s.db, log.Logger, func(tx *sql.Tx) error {
+		return ReplayEntries(tx, entries)
+	})
+}
@@ -0,0 +1,45 @@
+package ingest
+
+import (
+	"database/sql"
+
+	"github.com/rs/zerolog"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Writer persists validated entries.
+type Writer interface {
+	// Write stores the entries or queues them to be stored.
+	// Synchronous writers replace the IDs of duplicates with the stored ones.
+	Write(log zerolog.Logger, entries []*models.Entry) error
+}
+
+// SyncWriter writes entries to the DB within the calling request.
+// If the DB write fails with a transient error, e.g. the DB is unreachable, and a spooler
+// is set, entries are spooled instead. Permanent errors are returned as is.
+type SyncWriter struct {
+	db      *sql.DB
+	spooler *Spooler
+}
+
+func NewSyncWriter(db *sql.DB, spooler *Spooler) *SyncWriter {
+	return &SyncWriter{db: db, spooler: spooler}
+}
+
+func (w *SyncWriter) Write(log zerolog.Logger, entries []*models.Entry) error {
+	err := sqlutil.InTx(w.db, log, func(tx *sql.Tx) error {
+		return InsertEntries(tx, entries)
+	})
+	if err == nil || w.spooler == nil || !sqlutil.IsTransient(err) {
+		return err
+	}
+
+	if serr := w.spooler.Spool(entries); serr != nil {
+		log.Error().Err(serr).Msg("Spool entries")
+		return err
+	}
+	log.Warn().Err(err).Int("entries", len(entries)).Msg("DB write failed, entries spooled")
+	return nil
+}
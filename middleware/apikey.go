@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/apikey"
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+const API_KEY_HEADER = "X-Api-Key"
+
+// APIKeyMiddleware verifies the API key header of appends and sets the key as "API_KEY".
+// Appends without a key pass through unless required.
+// Scopes and quotas are checked by the handlers, knowing the appended entries.
+func APIKeyMiddleware(v *apikey.Verifier, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader(API_KEY_HEADER)
+		if token == "" {
+			if required {
+				httputil.NewUnauthorizedError(fmt.Errorf("expected an %s header", API_KEY_HEADER)).Abort(c)
+				return
+			}
+			c.Next()
+			return
+		}
+		key, err := v.Verify(token)
+		if err != nil {
+			if errors.Is(err, apikey.ErrInvalidToken) || errors.Is(err, apikey.ErrInactive) {
+				httputil.NewUnauthorizedError(err).Abort(c)
+			} else if sqlutil.IsTransient(err) {
+				c.Header("Retry-After", "1")
+				httputil.NewServiceUnavailableError(err).Abort(c)
+			} else {
+				httputil.NewInternalError(err).Abort(c)
+			}
+			return
+		}
+		c.Set("API_KEY", key)
+		c.Next()
+	}
+}
+
+// GetAPIKey returns the verified API key of the request, nil without one.
+func GetAPIKey(c *gin.Context) *apikey.Key {
+	if key, ok := c.Get("API_KEY"); ok {
+		return key.(*apikey.Key)
+	}
+	return nil
+}
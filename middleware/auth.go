@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/websocket"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/pkg/jwks"
+)
+
+const (
+	BEARER_PREFIX = "Bearer "
+	// Browsers can't set headers of WebSocket requests, these pass the token as a query
+	// parameter or as the subprotocol following ACCESS_TOKEN_PROTOCOL, which is then selected.
+	ACCESS_TOKEN_PARAM    = "access_token"
+	ACCESS_TOKEN_PROTOCOL = "access_token"
+)
+
+// Claims of keycloak access tokens.
+type Claims struct {
+	jwt.RegisteredClaims
+	AuthorizedParty   string `json:"azp,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	RealmAccess       struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+}
+
+// Authenticator verifies RS256 signed tokens with the keys of a JWKS.
+type Authenticator struct {
+	keys     *jwks.KeySet
+	issuer   string
+	clientID string
+	parser   *jwt.Parser
+}
+
+// NewAuthenticator verifies tokens are issued by issuer, and to clientID
+// (the audience or authorized party), unless empty.
+func NewAuthenticator(keys *jwks.KeySet, issuer, clientID string) *Authenticator {
+	return &Authenticator{
+		keys:     keys,
+		issuer:   issuer,
+		clientID: clientID,
+		parser:   jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()})),
+	}
+}
+
+func (a *Authenticator) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The parser only verifies exp when present.
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return nil, errors.New("expected token expiration")
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if a.clientID != "" && !claims.VerifyAudience(a.clientID, true) && claims.AuthorizedParty != a.clientID {
+		return nil, fmt.Errorf("token not issued to %q", a.clientID)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("expected token subject")
+	}
+	return claims, nil
+}
+
+// Returns the bearer token of the request, empty without one.
+func requestToken(c *gin.Context) (string, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if !strings.HasPrefix(header, BEARER_PREFIX) {
+			return "", errors.New("expected a bearer token")
+		}
+		return strings.TrimPrefix(header, BEARER_PREFIX), nil
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		return "", nil
+	}
+	if token := c.Query(ACCESS_TOKEN_PARAM); token != "" {
+		return token, nil
+	}
+	protocols := websocket.Subprotocols(c.Request)
+	for i, protocol := range protocols {
+		if protocol == ACCESS_TOKEN_PROTOCOL && i+1 < len(protocols) {
+			return protocols[i+1], nil
+		}
+	}
+	return "", nil
+}
+
+// AuthenticationMiddleware verifies bearer tokens and sets their claims as "CLAIMS".
+// Requests without a token pass through, see RequireAuthentication.
+// A nil authenticator passes all requests through, as with SKIP_AUTH.
+func AuthenticationMiddleware(a *Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+		token, err := requestToken(c)
+		if err != nil {
+			httputil.NewUnauthorizedError(err).Abort(c)
+			return
+		}
+		if token == "" {
+			c.Next()
+			return
+		}
+		claims, err := a.Verify(token)
+		if err != nil {
+			httputil.NewUnauthorizedError(fmt.Errorf("invalid token: %w", err)).Abort(c)
+			return
+		}
+		c.Set("CLAIMS", claims)
+		c.Next()
+	}
+}
+
+// RequireAuthentication rejects requests without a verified token, unless skipAuth.
+func RequireAuthentication(skipAuth bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !skipAuth && GetClaims(c) == nil {
+			httputil.NewUnauthorizedError(errors.New("expected an Authorization header")).Abort(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetClaims returns the claims of the request's token, nil without one.
+func GetClaims(c *gin.Context) *Claims {
+	if claims, ok := c.Get("CLAIMS"); ok {
+		return claims.(*Claims)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/jwks"
+)
+
+const (
+	TEST_ISSUER    = "https://accounts.example.com/auth/realms/main"
+	TEST_CLIENT_ID = "chronicles"
+)
+
+type AuthSuite struct {
+	suite.Suite
+	key    *rsa.PrivateKey
+	router *gin.Engine
+}
+
+func TestAuth(t *testing.T) {
+	suite.Run(t, new(AuthSuite))
+}
+
+func (suite *AuthSuite) SetupSuite() {
+	var err error
+	suite.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().Nil(err)
+
+	set := fmt.Sprintf(`{"keys": [{"kid": "test", "kty": "RSA", "use": "sig", "n": "%s", "e": "%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(suite.key.PublicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(suite.key.PublicKey.E)).Bytes()))
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Require().Nil(os.WriteFile(path, []byte(set), 0600))
+	keys, err := jwks.Load(path)
+	suite.Require().Nil(err)
+
+	gin.SetMode(gin.TestMode)
+	suite.router = gin.New()
+	suite.router.Use(
+		func(c *gin.Context) { c.Set("LOGGER", zerolog.Nop()) },
+		ErrorHandlingMiddleware(),
+		AuthenticationMiddleware(NewAuthenticator(keys, TEST_ISSUER, TEST_CLIENT_ID)))
+	suite.router.GET("/open", func(c *gin.Context) {
+		c.String(http.StatusOK, "open")
+	})
+	suite.router.GET("/me", RequireAuthentication(false), func(c *gin.Context) {
+		c.String(http.StatusOK, GetClaims(c).Subject)
+	})
+}
+
+func (suite *AuthSuite) sign(key *rsa.PrivateKey, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(key)
+	suite.Require().Nil(err)
+	return signed
+}
+
+func (suite *AuthSuite) get(path, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *AuthSuite) TestAuthentication() {
+	valid := jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    TEST_ISSUER,
+		Audience:  jwt.ClaimStrings{TEST_CLIENT_ID},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	w := suite.get("/me", "Bearer "+suite.sign(suite.key, valid))
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("user-1", w.Body.String())
+
+	// Token is not required, unless RequireAuthentication.
+	suite.Equal(http.StatusOK, suite.get("/open", "").Code)
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "").Code)
+
+	suite.Equal(http.StatusUnauthorized, suite.get("/open", "Basic dXNlcjpwYXNz").Code)
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+suite.sign(suite.key, expired)).Code)
+
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+suite.sign(suite.key, noExpiry)).Code)
+
+	// Keycloak access tokens name the client as the authorized party.
+	otherAudience := valid
+	otherAudience.Audience = jwt.ClaimStrings{"account"}
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+suite.sign(suite.key, otherAudience)).Code)
+	authorized := Claims{RegisteredClaims: otherAudience, AuthorizedParty: TEST_CLIENT_ID}
+	suite.Equal(http.StatusOK, suite.get("/me", "Bearer "+suite.sign(suite.key, authorized)).Code)
+
+	otherIssuer := valid
+	otherIssuer.Issuer = "https://evil.example.com"
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+suite.sign(suite.key, otherIssuer)).Code)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().Nil(err)
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+suite.sign(otherKey, valid)).Code)
+
+	// Only RS256 is accepted.
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, valid)
+	hs.Header["kid"] = "test"
+	signed, err := hs.SignedString([]byte("secret"))
+	suite.Require().Nil(err)
+	suite.Equal(http.StatusUnauthorized, suite.get("/me", "Bearer "+signed).Code)
+}
+
+func (suite *AuthSuite) TestWebSocketToken() {
+	token := suite.sign(suite.key, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    TEST_ISSUER,
+		Audience:  jwt.ClaimStrings{TEST_CLIENT_ID},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	upgrade := func(path, protocol string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if protocol != "" {
+			req.Header.Set("Sec-WebSocket-Protocol", protocol)
+		}
+		w := httptest.NewRecorder()
+		suite.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	suite.Equal(http.StatusOK, upgrade("/me?access_token="+token, ""))
+	suite.Equal(http.StatusOK, upgrade("/me", "access_token, "+token))
+	suite.Equal(http.StatusUnauthorized, upgrade("/me", "access_token"))
+	suite.Equal(http.StatusUnauthorized, upgrade("/me?access_token=invalid", ""))
+
+	// Other requests pass the token in the Authorization header only.
+	suite.Equal(http.StatusUnauthorized, suite.get("/me?access_token="+token, "").Code)
+}
+
+func (suite *AuthSuite) TestRedactedURI() {
+	req := httptest.NewRequest(http.MethodGet, "/tail?namespaces=archive&access_token=secret", nil)
+	suite.Equal("/tail?access_token=xxxxx&namespaces=archive", redactedURI(req))
+	req = httptest.NewRequest(http.MethodGet, "/tail?namespaces=archive", nil)
+	suite.Equal("/tail?namespaces=archive", redactedURI(req))
+}
@@ -4,11 +4,38 @@ import (
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/ingest"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+	"github.com/Bnei-Baruch/chronicles/rejected"
+	"github.com/Bnei-Baruch/chronicles/schema"
+	"github.com/Bnei-Baruch/chronicles/tail"
 )
 
-func ContextMiddleware(db *sql.DB) gin.HandlerFunc {
+func ContextMiddleware(db *sql.DB, writer ingest.Writer, hub *tail.Hub, limiter *ratelimit.Limiter, schemas *schema.Registry, rejections *rejected.Recorder) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set("DB", db)
+		c.Set("INGEST", writer)
+		c.Set("TAIL", hub)
+		c.Set("RATE_LIMITER", limiter)
+		c.Set("SCHEMAS", schemas)
+		c.Set("REJECTED", rejections)
 		c.Next()
 	}
 }
+
+// GetSchemas returns the schema registry set by ContextMiddleware, nil without one.
+func GetSchemas(c *gin.Context) *schema.Registry {
+	if schemas, ok := c.Get("SCHEMAS"); ok {
+		return schemas.(*schema.Registry)
+	}
+	return nil
+}
+
+// GetRejected returns the recorder of rejected entries set by ContextMiddleware, nil without one.
+func GetRejected(c *gin.Context) *rejected.Recorder {
+	if rejections, ok := c.Get("REJECTED"); ok {
+		return rejections.(*rejected.Recorder)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -14,6 +15,12 @@ import (
 	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
 )
 
+// DetailedError adds fields to the error response of public errors.
+type DetailedError interface {
+	error
+	ErrorDetails() map[string]interface{}
+}
+
 func ValidationErrorMessage(e validator.FieldError) string {
 	switch e.Tag() {
 	case "required":
@@ -61,7 +68,14 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 			case gin.ErrorTypePublic:
 				if e.Err != nil {
 					log.Warn().Msgf("Public error: %s", e.Error())
-					c.JSON(c.Writer.Status(), gin.H{"status": "error", "error": e.Error()})
+					body := gin.H{"status": "error", "error": e.Error()}
+					var detailed DetailedError
+					if errors.As(e.Err, &detailed) {
+						for k, v := range detailed.ErrorDetails() {
+							body[k] = v
+						}
+					}
+					c.JSON(c.Writer.Status(), body)
 				}
 
 			case gin.ErrorTypeBind:
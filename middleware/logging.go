@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -50,7 +51,7 @@ func LoggingMiddleware() gin.HandlerFunc {
 
 		// log line (see hlog.AccessHandler)
 		r := c.Request
-		path := r.URL.RequestURI() // some evil middleware modify this values
+		path := redactedURI(r) // some evil middleware modify this values
 
 		c.Next()
 
@@ -64,3 +65,15 @@ func LoggingMiddleware() gin.HandlerFunc {
 			Msg("")
 	}
 }
+
+// Request URI without the access token of WebSocket requests, see requestToken.
+func redactedURI(r *http.Request) string {
+	query := r.URL.Query()
+	if query.Get(ACCESS_TOKEN_PARAM) == "" {
+		return r.URL.RequestURI()
+	}
+	query.Set(ACCESS_TOKEN_PARAM, "xxxxx")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parses a comma separated list of IPs and CIDRs of trusted reverse proxies.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", v)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// RealIPMiddleware replaces the remote address of requests coming through trusted proxies
+// with the client's one from X-Forwarded-For or X-Real-Ip. The router must not trust these
+// headers itself (ForwardedByClientIP false) so that c.ClientIP() can't be spoofed.
+func RealIPMiddleware(proxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ip := realIP(c.Request, proxies); ip != "" {
+			c.Request.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		c.Next()
+	}
+}
+
+// Walks X-Forwarded-For from the nearest hop, skipping trusted proxies.
+// Returns "" when the remote address should be kept.
+func realIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil || !trusted(net.ParseIP(host), proxies) {
+		return ""
+	}
+	ip := ""
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop.String()
+			if !trusted(hop, proxies) {
+				break
+			}
+		}
+	} else if hop := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); hop != nil {
+		ip = hop.String()
+	}
+	return ip
+}
+
+func trusted(ip net.IP, proxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProxiesSuite struct {
+	suite.Suite
+}
+
+func TestProxies(t *testing.T) {
+	suite.Run(t, new(ProxiesSuite))
+}
+
+func (suite *ProxiesSuite) TestParseTrustedProxies() {
+	proxies, err := ParseTrustedProxies("")
+	suite.Require().Nil(err)
+	suite.Empty(proxies)
+
+	proxies, err = ParseTrustedProxies("10.0.0.0/8, 127.0.0.1,::1")
+	suite.Require().Nil(err)
+	suite.Len(proxies, 3)
+	suite.Equal("127.0.0.1/32", proxies[1].String())
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	suite.NotNil(err)
+	_, err = ParseTrustedProxies("localhost")
+	suite.NotNil(err)
+}
+
+func (suite *ProxiesSuite) TestRealIP() {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	suite.Require().Nil(err)
+	r := httptest.NewRequest("GET", "/", nil)
+
+	// Headers of untrusted remotes are ignored.
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	suite.Equal("", realIP(r, proxies))
+
+	// Spoofed hops before the nearest untrusted one are ignored.
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8, 1.2.3.4, 10.0.0.2")
+	suite.Equal("1.2.3.4", realIP(r, proxies))
+
+	r.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	suite.Equal("10.0.0.3", realIP(r, proxies))
+
+	r.Header.Set("X-Forwarded-For", "garbage, 1.2.3.4")
+	suite.Equal("1.2.3.4", realIP(r, proxies))
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-Ip", "1.2.3.4")
+	suite.Equal("1.2.3.4", realIP(r, proxies))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+	"github.com/Bnei-Baruch/chronicles/ratelimit"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitMiddleware limits requests per client IP.
+// Events are limited per user and namespace by the handlers, knowing the appended entries.
+func RateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := GetRateLimiter(c)
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		if ok, retryAfter := limiter.Allow(ratelimit.IP, c.ClientIP(), 1); !ok {
+			c.Header("Retry-After", httputil.RetryAfter(retryAfter))
+			httputil.NewTooManyRequestsError(ErrRateLimited).Abort(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetRateLimiter returns the limiter set by ContextMiddleware, nil without one.
+func GetRateLimiter(c *gin.Context) *ratelimit.Limiter {
+	if limiter, ok := c.Get("RATE_LIMITER"); ok {
+		return limiter.(*ratelimit.Limiter)
+	}
+	return nil
+}
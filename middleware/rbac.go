@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/httputil"
+)
+
+const (
+	// Appends only, the role of tokens without a chronicles role and of API keys.
+	ROLE_INGEST = "ingest"
+	// Reads entries, restricted by the role's policy.
+	ROLE_ANALYST = "analyst"
+	// Reads everything and manages chronicles.
+	ROLE_ADMIN = "admin"
+
+	// Keycloak realm roles prefix, e.g., chronicles_analyst.
+	ROLE_CLAIM_PREFIX = "chronicles_"
+)
+
+// Roles from least to most privileged, tokens get the highest role they carry.
+var roles = []string{ROLE_INGEST, ROLE_ANALYST, ROLE_ADMIN}
+
+// Columns of entries a policy may mask.
+var maskableColumns = map[string]bool{"ip_addr": true, "user_agent": true, "data": true}
+
+// Policy restricts what a role reads.
+type Policy struct {
+	// Namespace prefixes the role reads, all namespaces when empty.
+	Namespaces []string `json:"namespaces"`
+	// Columns returned masked: ip_addr, user_agent or data.
+	Masked []string `json:"masked"`
+}
+
+// AllowsNamespace returns whether the namespace starts with one of the policy's prefixes.
+func (p *Policy) AllowsNamespace(namespace string) bool {
+	if len(p.Namespaces) == 0 {
+		return true
+	}
+	for _, prefix := range p.Namespaces {
+		if strings.HasPrefix(namespace, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Masks returns whether the column is masked.
+func (p *Policy) Masks(column string) bool {
+	for _, c := range p.Masked {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPolicies mask analysts the client's IP address and user agent.
+func DefaultPolicies() map[string]*Policy {
+	return map[string]*Policy{
+		ROLE_INGEST:  {},
+		ROLE_ANALYST: {Masked: []string{"ip_addr", "user_agent"}},
+		ROLE_ADMIN:   {},
+	}
+}
+
+// ParsePolicies overrides the default policies with a JSON object of policies by role,
+// e.g., {"analyst": {"namespaces": ["archive"], "masked": ["ip_addr", "user_agent", "data"]}}.
+func ParsePolicies(s string) (map[string]*Policy, error) {
+	policies := DefaultPolicies()
+	if s == "" {
+		return policies, nil
+	}
+	overrides := map[string]*Policy{}
+	if err := json.Unmarshal([]byte(s), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid role policies: %w", err)
+	}
+	for role, policy := range overrides {
+		if _, ok := policies[role]; !ok {
+			return nil, fmt.Errorf("unknown role %q, expected ingest, analyst or admin", role)
+		}
+		for _, column := range policy.Masked {
+			if !maskableColumns[column] {
+				return nil, fmt.Errorf("unexpected masked column %q of role %s, expected ip_addr, user_agent or data", column, role)
+			}
+		}
+		policies[role] = policy
+	}
+	return policies, nil
+}
+
+// RoleOf returns the highest chronicles role of the token, ROLE_INGEST without one.
+func RoleOf(claims *Claims) string {
+	role := ROLE_INGEST
+	for i, r := range roles {
+		for _, claimed := range claims.RealmAccess.Roles {
+			if claimed == ROLE_CLAIM_PREFIX+r && i > roleRank(role) {
+				role = r
+			}
+		}
+	}
+	return role
+}
+
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// RoleMiddleware sets the role of the request as "ROLE" and its policy as "POLICY".
+// Requests are admin when skipAuth, anonymous requests have no role.
+func RoleMiddleware(skipAuth bool, policies map[string]*Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := ""
+		if claims := GetClaims(c); claims != nil {
+			role = RoleOf(claims)
+		} else if skipAuth {
+			role = ROLE_ADMIN
+		}
+		if role != "" {
+			c.Set("ROLE", role)
+			c.Set("POLICY", policies[role])
+		}
+		c.Next()
+	}
+}
+
+// RequireRole rejects anonymous requests and requests of other roles.
+func RequireRole(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := GetRole(c)
+		if role == "" {
+			httputil.NewUnauthorizedError(errors.New("expected an Authorization header")).Abort(c)
+			return
+		}
+		for _, r := range allowed {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		httputil.NewForbiddenError(fmt.Errorf("role %s is not allowed, expected %s", role, strings.Join(allowed, " or "))).Abort(c)
+	}
+}
+
+// GetRole returns the role of the request, empty when anonymous.
+func GetRole(c *gin.Context) string {
+	return c.GetString("ROLE")
+}
+
+// GetPolicy returns the policy of the request's role, nil when anonymous.
+func GetPolicy(c *gin.Context) *Policy {
+	if policy, ok := c.Get("POLICY"); ok {
+		return policy.(*Policy)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+)
+
+type RBACSuite struct {
+	suite.Suite
+}
+
+func TestRBAC(t *testing.T) {
+	suite.Run(t, new(RBACSuite))
+}
+
+func (suite *RBACSuite) TestParsePolicies() {
+	policies, err := ParsePolicies("")
+	suite.Require().Nil(err)
+	suite.True(policies[ROLE_ANALYST].Masks("ip_addr"))
+	suite.False(policies[ROLE_ADMIN].Masks("ip_addr"))
+
+	policies, err = ParsePolicies(`{"analyst": {"namespaces": ["archive"], "masked": ["data"]}}`)
+	suite.Require().Nil(err)
+	suite.True(policies[ROLE_ANALYST].Masks("data"))
+	suite.False(policies[ROLE_ANALYST].Masks("ip_addr"))
+	suite.True(policies[ROLE_ANALYST].AllowsNamespace("archive_v1.2.3"))
+	suite.False(policies[ROLE_ANALYST].AllowsNamespace("kmedia"))
+	suite.True(policies[ROLE_ADMIN].AllowsNamespace("kmedia"))
+
+	_, err = ParsePolicies(`{"guest": {}}`)
+	suite.NotNil(err)
+	_, err = ParsePolicies(`{"analyst": {"masked": ["user_id"]}}`)
+	suite.NotNil(err)
+	_, err = ParsePolicies(`[]`)
+	suite.NotNil(err)
+}
+
+func (suite *RBACSuite) TestRoleOf() {
+	claims := &Claims{}
+	suite.Equal(ROLE_INGEST, RoleOf(claims))
+	claims.RealmAccess.Roles = []string{"offline_access", "chronicles_analyst"}
+	suite.Equal(ROLE_ANALYST, RoleOf(claims))
+	claims.RealmAccess.Roles = []string{"chronicles_admin", "chronicles_analyst"}
+	suite.Equal(ROLE_ADMIN, RoleOf(claims))
+	claims.RealmAccess.Roles = []string{"admin"}
+	suite.Equal(ROLE_INGEST, RoleOf(claims))
+}
+
+func (suite *RBACSuite) TestRequireRole() {
+	policies := DefaultPolicies()
+	gin.SetMode(gin.TestMode)
+	get := func(skipAuth bool, claims *Claims) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(
+			func(c *gin.Context) { c.Set("LOGGER", zerolog.Nop()) },
+			ErrorHandlingMiddleware(),
+			func(c *gin.Context) {
+				if claims != nil {
+					c.Set("CLAIMS", claims)
+				}
+			},
+			RoleMiddleware(skipAuth, policies))
+		router.GET("/read", RequireRole(ROLE_ANALYST, ROLE_ADMIN), func(c *gin.Context) {
+			suite.Same(policies[GetRole(c)], GetPolicy(c))
+			c.String(http.StatusOK, GetRole(c))
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/read", nil))
+		return w
+	}
+
+	suite.Equal(http.StatusUnauthorized, get(false, nil).Code)
+	suite.Equal(http.StatusForbidden, get(false, &Claims{}).Code)
+
+	analyst := &Claims{}
+	analyst.RealmAccess.Roles = []string{"chronicles_analyst"}
+	w := get(false, analyst)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal(ROLE_ANALYST, w.Body.String())
+
+	w = get(true, nil)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal(ROLE_ADMIN, w.Body.String())
+}
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY namespace_client_event_id_unique_index;
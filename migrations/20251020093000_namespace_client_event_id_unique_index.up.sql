@@ -0,0 +1,4 @@
+-- Delete duplicates left behind by retried appends with `chronicles dedupe` first, the index can't be
+-- built over them. Must be the only statement, CONCURRENTLY can't run in a transaction. If it fails,
+-- e.g., on new duplicates, drop the invalid index and dedupe again before migrating.
+CREATE UNIQUE INDEX CONCURRENTLY namespace_client_event_id_unique_index ON entries (namespace, client_event_id) WHERE client_event_id IS NOT NULL;
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY client_session_id_index;
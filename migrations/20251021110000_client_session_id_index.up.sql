@@ -0,0 +1,2 @@
+-- Must be the only statement, CONCURRENTLY can't run in a transaction.
+CREATE INDEX CONCURRENTLY client_session_id_index ON entries (client_session_id);
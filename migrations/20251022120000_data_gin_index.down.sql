@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY data_gin_index;
@@ -0,0 +1,3 @@
+-- Supports the @> containment queries of /scan data filters.
+-- Must be the only statement, CONCURRENTLY can't run in a transaction.
+CREATE INDEX CONCURRENTLY data_gin_index ON entries USING GIN (data jsonb_path_ops);
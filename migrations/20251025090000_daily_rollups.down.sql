@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rollup_state;
+DROP TABLE IF EXISTS daily_rollups;
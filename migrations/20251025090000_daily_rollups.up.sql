@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS daily_rollups
+(
+    day               DATE        NOT NULL, -- UTC day of created_at
+    namespace         VARCHAR(64) NOT NULL,
+    client_event_type VARCHAR(64) NOT NULL,
+
+    events            BIGINT      NOT NULL,
+    users             BYTEA       NOT NULL, -- HyperLogLog sketches of distinct user_id, see pkg/hll.
+    incognito_users   BYTEA       NOT NULL, -- Only "client:local:" user ids.
+    logged_in_users   BYTEA       NOT NULL, -- Only keycloak user ids.
+
+    PRIMARY KEY (day, namespace, client_event_type)
+);
+
+-- Progress of incremental jobs over entries, in KSUID order.
+CREATE TABLE IF NOT EXISTS rollup_state
+(
+    name       VARCHAR(64) PRIMARY KEY,
+    last_id    CHAR(27) COLLATE "POSIX"               NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL
+);
+
+INSERT INTO rollup_state (name, last_id) VALUES ('daily_rollups', '000000000000000000000000000');
@@ -0,0 +1,2 @@
+DELETE FROM rollup_state WHERE name = 'first_seen';
+DROP TABLE IF EXISTS first_seen;
@@ -0,0 +1,13 @@
+-- First entry of every user in a namespace, maintained incrementally like daily_rollups.
+CREATE TABLE IF NOT EXISTS first_seen
+(
+    namespace     VARCHAR(64)              NOT NULL,
+    user_id       VARCHAR(64)              NOT NULL,
+    first_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY (namespace, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS first_seen_namespace_first_seen_at_idx ON first_seen USING BTREE (namespace, first_seen_at);
+
+INSERT INTO rollup_state (name, last_id) VALUES ('first_seen', '000000000000000000000000000');
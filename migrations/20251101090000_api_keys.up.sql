@@ -0,0 +1,25 @@
+-- Ingestion API keys, scoped to namespace prefixes and event types. Empty scopes allow all.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          CHAR(27)                 NOT NULL PRIMARY KEY,
+    name        VARCHAR(128)             NOT NULL,
+    secret_hash BYTEA                    NOT NULL,
+    namespaces  TEXT[]                   NOT NULL DEFAULT '{}',
+    event_types TEXT[]                   NOT NULL DEFAULT '{}',
+    -- Events per UTC day, 0 is unlimited.
+    daily_quota BIGINT                   NOT NULL DEFAULT 0,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    -- Set on rotation, the replaced key keeps working until then.
+    expires_at  TIMESTAMP WITH TIME ZONE NULL,
+    revoked_at  TIMESTAMP WITH TIME ZONE NULL,
+    replaced_by CHAR(27)                 NULL REFERENCES api_keys (id)
+);
+
+CREATE TABLE IF NOT EXISTS api_key_usage
+(
+    key_id CHAR(27) NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    day    DATE     NOT NULL,
+    events BIGINT   NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (key_id, day)
+);
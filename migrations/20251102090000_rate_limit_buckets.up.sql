@@ -0,0 +1,10 @@
+-- Token buckets of rate limits shared by replicas, rows are deleted once their bucket is full.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets
+(
+    key        VARCHAR(256)             NOT NULL PRIMARY KEY,
+    tokens     DOUBLE PRECISION         NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    full_at    TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_full_at_idx ON rate_limit_buckets USING BTREE (full_at);
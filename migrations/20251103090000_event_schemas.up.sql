@@ -0,0 +1,13 @@
+-- Versioned JSON Schemas of the data of entries per (namespace, client_event_type).
+-- Appends are validated against the latest version, by its mode.
+CREATE TABLE IF NOT EXISTS event_schemas
+(
+    namespace         VARCHAR(64)              NOT NULL,
+    client_event_type VARCHAR(64)              NOT NULL,
+    version           INTEGER                  NOT NULL,
+    schema            JSONB                    NOT NULL,
+    mode              VARCHAR(16)              NOT NULL DEFAULT 'enforce' CHECK (mode IN ('enforce', 'warn', 'off')),
+    created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (namespace, client_event_type, version)
+);
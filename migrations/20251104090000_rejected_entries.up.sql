@@ -0,0 +1,24 @@
+-- Appends rejected by validation, kept for REJECTED_TTL to debug clients and replay
+-- them once fixed. Columns are unconstrained, rejected bodies may be anything.
+CREATE TABLE IF NOT EXISTS rejected_entries
+(
+    id                CHAR(27)                 NOT NULL PRIMARY KEY, -- KSUID of created_at
+    created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    endpoint          VARCHAR(16)              NOT NULL,             -- /append or /appends
+    batch_index       INTEGER                  NULL,                 -- Index in /appends, body is then the single append request
+    namespace         TEXT                     NOT NULL DEFAULT '',  -- Empty when the body couldn't be parsed
+    client_event_type TEXT                     NOT NULL DEFAULT '',
+    reason            TEXT                     NOT NULL,
+    body              TEXT                     NOT NULL,
+
+    ip_addr           INET                     NOT NULL,
+    user_agent        TEXT                     NOT NULL,
+    keycloak_subject  VARCHAR(64)              NULL,                 -- Verified token subject, allowing to replay its keycloak_id
+    api_key_id        CHAR(27)                 NULL,
+
+    replayed_at       TIMESTAMP WITH TIME ZONE NULL
+);
+
+CREATE INDEX IF NOT EXISTS rejected_entries_created_at_idx ON rejected_entries (created_at);
+CREATE INDEX IF NOT EXISTS rejected_entries_namespace_id_idx ON rejected_entries (namespace, id);
@@ -0,0 +1,5 @@
+ALTER TABLE entries
+    DROP COLUMN IF EXISTS ua_browser,
+    DROP COLUMN IF EXISTS ua_os,
+    DROP COLUMN IF EXISTS ua_device,
+    DROP COLUMN IF EXISTS ua_bot;
@@ -0,0 +1,6 @@
+-- Parsed from user_agent on append (see pkg/useragent), NULL for entries not backfilled yet.
+ALTER TABLE entries
+    ADD COLUMN IF NOT EXISTS ua_browser VARCHAR(64) NULL,
+    ADD COLUMN IF NOT EXISTS ua_os      VARCHAR(64) NULL,
+    ADD COLUMN IF NOT EXISTS ua_device  VARCHAR(16) NULL, -- desktop, mobile, tablet, bot or other
+    ADD COLUMN IF NOT EXISTS ua_bot     BOOLEAN     NULL;
@@ -0,0 +1,10 @@
+-- Entries written with ids older than rollup_state.last_id may have been, e.g., replayed
+-- from the ingest spool or from rejected_entries. They are queued per job to be processed
+-- apart from the last_id watermark.
+CREATE TABLE IF NOT EXISTS rollup_pending
+(
+    name     VARCHAR(64) NOT NULL REFERENCES rollup_state (name) ON DELETE CASCADE,
+    entry_id CHAR(27) COLLATE "POSIX" NOT NULL,
+
+    PRIMARY KEY (name, entry_id)
+);
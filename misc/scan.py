@@ -10,6 +10,8 @@ from requests.exceptions import JSONDecodeError
 parser = argparse.ArgumentParser(description="Scan chronicles")
 
 parser.add_argument("-c", "--cache", type=str, help="Cache csv file.")
+parser.add_argument("-f", "--from", dest="from_time", type=str, default="2025-01-14T00:00:00Z",
+                    help="Scan entries created from this time (RFC3339).")
 # parser.add_argument("-o", "--output", type=str, help="Translated docx")
 
 args = parser.parse_args()
@@ -17,12 +19,14 @@ args = parser.parse_args()
 # FIELDS = ["id", "namespace", "created_at", "user_id"]
 FIELDS = ["id", "namespace", "created_at", "user_id", "ip_addr", "user_agent", "client_event_id", "client_event_type", "client_flow_id", "client_flow_type", "client_session_id", "data"]
 LIMIT = 500000
+# Bearer token, see "Try out the API" in README.md.
+TOKEN = os.environ.get("CHRONICLES_TOKEN", "")
 
 def scan(id, retries):
     try:
         response = requests.post("https://chronicles.kli.one/scan",
-                data=json.dumps({"id": id, "fields": FIELDS, "limit": LIMIT}),
-                headers={"Content-Type": "application/json"},
+                data=json.dumps({"id": id, "from": args.from_time, "fields": FIELDS, "limit": LIMIT}),
+                headers={"Content-Type": "application/json", "Authorization": f"Bearer {TOKEN}"},
         )
         #print(f"{response}")
         json_response = response.json()
@@ -107,9 +111,7 @@ def process_entry(entry, visits):
 def main():
     entries = []
     visits = {}
-    entry_id = "2rd"  # 14th Jan 25. (9M entries up to 22nd Jan)
-    # entry_id = "2r0I"  # end of 24 (19M entries)
-    # entry_id = "2aKV"  # end of 23 (445M entries)
+    entry_id = ""  # Start from --from, the server converts it to an id lower bound.
 
     # Restore from cache
     read_count = 0
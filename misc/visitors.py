@@ -0,0 +1,38 @@
+import argparse
+import os
+import requests
+
+from collections import defaultdict
+
+# Prints distinct visitors per namespace computed by the /stats/visitors endpoint.
+
+parser = argparse.ArgumentParser(description="Chronicles visitors report")
+
+parser.add_argument("-f", "--from", dest="from_date", type=str, required=True, help="From date (YYYY-MM-DD), inclusive.")
+parser.add_argument("-t", "--to", dest="to_date", type=str, required=True, help="To date (YYYY-MM-DD), exclusive.")
+parser.add_argument("-n", "--namespace", dest="namespaces", action="append", default=[], help="Namespace, may repeat.")
+parser.add_argument("-u", "--url", type=str, default="https://chronicles.kli.one", help="Chronicles url.")
+
+args = parser.parse_args()
+
+
+def main():
+    response = requests.get(f"{args.url}/stats/visitors", params={
+        "from": args.from_date,
+        "to": args.to_date,
+        "namespaces": args.namespaces,
+    }, headers={"Authorization": f"Bearer {os.environ.get('CHRONICLES_TOKEN', '')}"})
+    response.raise_for_status()
+
+    visits = defaultdict(list)
+    for period in response.json()["visitors"]:
+        visits[period["namespace"]].append(period)
+
+    for (namespace, periods) in visits.items():
+        print(f"{namespace} - {len(periods)}")
+        for period in periods:
+            print(f"\t{period['period']} - {period['visitors']} - incognito({period['incognito']}) - logged in({period['logged_in']})")
+
+
+if __name__ == "__main__":
+    main()
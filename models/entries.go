@@ -35,6 +35,10 @@ type Entry struct {
 	ClientFlowType  null.String `boil:"client_flow_type" json:"client_flow_type,omitempty" toml:"client_flow_type" yaml:"client_flow_type,omitempty"`
 	ClientSessionID null.String `boil:"client_session_id" json:"client_session_id,omitempty" toml:"client_session_id" yaml:"client_session_id,omitempty"`
 	Data            null.JSON   `boil:"data" json:"data,omitempty" toml:"data" yaml:"data,omitempty"`
+	UaBrowser       null.String `boil:"ua_browser" json:"ua_browser,omitempty" toml:"ua_browser" yaml:"ua_browser,omitempty"`
+	UaOs            null.String `boil:"ua_os" json:"ua_os,omitempty" toml:"ua_os" yaml:"ua_os,omitempty"`
+	UaDevice        null.String `boil:"ua_device" json:"ua_device,omitempty" toml:"ua_device" yaml:"ua_device,omitempty"`
+	UaBot           null.Bool   `boil:"ua_bot" json:"ua_bot,omitempty" toml:"ua_bot" yaml:"ua_bot,omitempty"`
 
 	R *entryR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L entryL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -53,6 +57,10 @@ var EntryColumns = struct {
 	ClientFlowType  string
 	ClientSessionID string
 	Data            string
+	UaBrowser       string
+	UaOs            string
+	UaDevice        string
+	UaBot           string
 }{
 	ID:              "id",
 	CreatedAt:       "created_at",
@@ -66,6 +74,10 @@ var EntryColumns = struct {
 	ClientFlowType:  "client_flow_type",
 	ClientSessionID: "client_session_id",
 	Data:            "data",
+	UaBrowser:       "ua_browser",
+	UaOs:            "ua_os",
+	UaDevice:        "ua_device",
+	UaBot:           "ua_bot",
 }
 
 var EntryTableColumns = struct {
@@ -81,6 +93,10 @@ var EntryTableColumns = struct {
 	ClientFlowType  string
 	ClientSessionID string
 	Data            string
+	UaBrowser       string
+	UaOs            string
+	UaDevice        string
+	UaBot           string
 }{
 	ID:              "entries.id",
 	CreatedAt:       "entries.created_at",
@@ -94,6 +110,10 @@ var EntryTableColumns = struct {
 	ClientFlowType:  "entries.client_flow_type",
 	ClientSessionID: "entries.client_session_id",
 	Data:            "entries.data",
+	UaBrowser:       "entries.ua_browser",
+	UaOs:            "entries.ua_os",
+	UaDevice:        "entries.ua_device",
+	UaBot:           "entries.ua_bot",
 }
 
 // Generated where
@@ -190,6 +210,30 @@ func (w whereHelpernull_JSON) GTE(x null.JSON) qm.QueryMod {
 func (w whereHelpernull_JSON) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
 func (w whereHelpernull_JSON) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
 
+type whereHelpernull_Bool struct{ field string }
+
+func (w whereHelpernull_Bool) EQ(x null.Bool) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_Bool) NEQ(x null.Bool) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_Bool) LT(x null.Bool) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_Bool) LTE(x null.Bool) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_Bool) GT(x null.Bool) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_Bool) GTE(x null.Bool) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
+func (w whereHelpernull_Bool) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_Bool) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+
 var EntryWhere = struct {
 	ID              whereHelperstring
 	CreatedAt       whereHelpertime_Time
@@ -203,6 +247,10 @@ var EntryWhere = struct {
 	ClientFlowType  whereHelpernull_String
 	ClientSessionID whereHelpernull_String
 	Data            whereHelpernull_JSON
+	UaBrowser       whereHelpernull_String
+	UaOs            whereHelpernull_String
+	UaDevice        whereHelpernull_String
+	UaBot           whereHelpernull_Bool
 }{
 	ID:              whereHelperstring{field: "\"entries\".\"id\""},
 	CreatedAt:       whereHelpertime_Time{field: "\"entries\".\"created_at\""},
@@ -216,6 +264,10 @@ var EntryWhere = struct {
 	ClientFlowType:  whereHelpernull_String{field: "\"entries\".\"client_flow_type\""},
 	ClientSessionID: whereHelpernull_String{field: "\"entries\".\"client_session_id\""},
 	Data:            whereHelpernull_JSON{field: "\"entries\".\"data\""},
+	UaBrowser:       whereHelpernull_String{field: "\"entries\".\"ua_browser\""},
+	UaOs:            whereHelpernull_String{field: "\"entries\".\"ua_os\""},
+	UaDevice:        whereHelpernull_String{field: "\"entries\".\"ua_device\""},
+	UaBot:           whereHelpernull_Bool{field: "\"entries\".\"ua_bot\""},
 }
 
 // EntryRels is where relationship names are stored.
@@ -235,9 +287,9 @@ func (*entryR) NewStruct() *entryR {
 type entryL struct{}
 
 var (
-	entryAllColumns            = []string{"id", "created_at", "user_id", "ip_addr", "user_agent", "namespace", "client_event_id", "client_event_type", "client_flow_id", "client_flow_type", "client_session_id", "data"}
+	entryAllColumns            = []string{"id", "created_at", "user_id", "ip_addr", "user_agent", "namespace", "client_event_id", "client_event_type", "client_flow_id", "client_flow_type", "client_session_id", "data", "ua_browser", "ua_os", "ua_device", "ua_bot"}
 	entryColumnsWithoutDefault = []string{"id", "user_id", "ip_addr", "user_agent", "namespace", "client_event_type"}
-	entryColumnsWithDefault    = []string{"created_at", "client_event_id", "client_flow_id", "client_flow_type", "client_session_id", "data"}
+	entryColumnsWithDefault    = []string{"created_at", "client_event_id", "client_flow_id", "client_flow_type", "client_session_id", "data", "ua_browser", "ua_os", "ua_device", "ua_bot"}
 	entryPrimaryKeyColumns     = []string{"id"}
 	entryGeneratedColumns      = []string{}
 )
@@ -0,0 +1,95 @@
+// Package hll implements a HyperLogLog sketch for estimating the number of
+// distinct values, see Flajolet et al. "HyperLogLog: the analysis of a
+// near-optimal cardinality estimation algorithm".
+package hll
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// 2^12 registers give a standard error of about 1.6%.
+	PRECISION = 12
+	REGISTERS = 1 << PRECISION
+	VERSION   = 1
+)
+
+var ErrInvalid = errors.New("hll: invalid sketch")
+
+type Sketch struct {
+	registers [REGISTERS]uint8
+}
+
+func New() *Sketch {
+	return &Sketch{}
+}
+
+// splitmix64 finalizer, spreads FNV hashes over all bits.
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+func (s *Sketch) Add(value string) {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	h := mix(f.Sum64())
+
+	index := h >> (64 - PRECISION)
+	rank := uint8(bits.LeadingZeros64(h<<PRECISION|1<<(PRECISION-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge makes s the sketch of the union of both sketches.
+func (s *Sketch) Merge(other *Sketch) {
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+func (s *Sketch) Estimate() uint64 {
+	m := float64(REGISTERS)
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Small range correction with linear counting.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary encodes the sketch as a version byte followed by the registers.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 1+REGISTERS)
+	b[0] = VERSION
+	copy(b[1:], s.registers[:])
+	return b, nil
+}
+
+func (s *Sketch) UnmarshalBinary(b []byte) error {
+	if len(b) != 1+REGISTERS || b[0] != VERSION {
+		return ErrInvalid
+	}
+	copy(s.registers[:], b[1:])
+	return nil
+}
@@ -0,0 +1,53 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HllSuite struct {
+	suite.Suite
+}
+
+func TestHll(t *testing.T) {
+	suite.Run(t, new(HllSuite))
+}
+
+func (suite *HllSuite) assertEstimate(s *Sketch, expected int) {
+	estimate := float64(s.Estimate())
+	suite.InDelta(float64(expected), estimate, math.Max(2, 0.05*float64(expected)))
+}
+
+func (suite *HllSuite) TestEstimate() {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		s := New()
+		for i := 0; i < n; i++ {
+			s.Add(fmt.Sprintf("client:local:%d", i))
+			// Duplicates are not counted.
+			s.Add(fmt.Sprintf("client:local:%d", i))
+		}
+		suite.assertEstimate(s, n)
+	}
+}
+
+func (suite *HllSuite) TestMergeAndMarshal() {
+	a, b := New(), New()
+	for i := 0; i < 30000; i++ {
+		a.Add(fmt.Sprintf("user-%d", i))
+	}
+	for i := 20000; i < 50000; i++ {
+		b.Add(fmt.Sprintf("user-%d", i))
+	}
+	a.Merge(b)
+	suite.assertEstimate(a, 50000)
+
+	data, err := a.MarshalBinary()
+	suite.Require().Nil(err)
+	c := New()
+	suite.Require().Nil(c.UnmarshalBinary(data))
+	suite.Equal(a.Estimate(), c.Estimate())
+	suite.Equal(ErrInvalid, c.UnmarshalBinary(data[1:]))
+}
@@ -1,7 +1,11 @@
 package httputil
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,7 +29,20 @@ func NewHttpError(code int, err error, t gin.ErrorType) *HttpError {
 }
 
 func NewNotFoundError() *HttpError {
-	return &HttpError{Code: http.StatusNotFound, Type: gin.ErrorTypePublic}
+	// gin panics on aborting with a nil error.
+	return NewHttpError(http.StatusNotFound, errors.New(http.StatusText(http.StatusNotFound)), gin.ErrorTypePublic)
+}
+
+func NewUnauthorizedError(err error) *HttpError {
+	return NewHttpError(http.StatusUnauthorized, err, gin.ErrorTypePublic)
+}
+
+func NewForbiddenError(err error) *HttpError {
+	return NewHttpError(http.StatusForbidden, err, gin.ErrorTypePublic)
+}
+
+func NewTooManyRequestsError(err error) *HttpError {
+	return NewHttpError(http.StatusTooManyRequests, err, gin.ErrorTypePublic)
 }
 
 func NewBadRequestError(err error) *HttpError {
@@ -35,3 +52,16 @@ func NewBadRequestError(err error) *HttpError {
 func NewInternalError(err error) *HttpError {
 	return NewHttpError(http.StatusInternalServerError, err, gin.ErrorTypePrivate)
 }
+
+func NewServiceUnavailableError(err error) *HttpError {
+	return NewHttpError(http.StatusServiceUnavailable, err, gin.ErrorTypePublic)
+}
+
+// RetryAfter formats a Retry-After header value of at least a second.
+func RetryAfter(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,139 @@
+// Package jwks loads RSA public keys from a JSON Web Key Set, see RFC 7517.
+package jwks
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FETCH_TIMEOUT = 10 * time.Second
+	// Unknown key ids trigger a refresh at most once in this interval.
+	REFRESH_INTERVAL = time.Minute
+)
+
+var ErrUnknownKey = errors.New("jwks: unknown key id")
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// Parse returns the RSA signing keys of the set by key id, other keys are ignored.
+func Parse(b []byte) (map[string]*rsa.PublicKey, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q modulus: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q exponent: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// KeySet holds the keys of a JWKS loaded from an http(s) URL or a local file.
+// Keys of URLs are refreshed when an unknown key id is looked up, e.g., after key rotation.
+type KeySet struct {
+	source string
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	refreshedAt time.Time
+}
+
+// Load loads the keys from source, either an http(s) URL or a file path.
+func Load(source string) (*KeySet, error) {
+	ks := &KeySet{source: source}
+	keys, err := ks.load()
+	if err != nil {
+		return nil, err
+	}
+	ks.keys = keys
+	ks.refreshedAt = time.Now()
+	return ks, nil
+}
+
+func (ks *KeySet) isURL() bool {
+	return strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://")
+}
+
+func (ks *KeySet) load() (map[string]*rsa.PublicKey, error) {
+	if !ks.isURL() {
+		b, err := os.ReadFile(ks.source)
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		return Parse(b)
+	}
+
+	client := http.Client{Timeout: FETCH_TIMEOUT}
+	resp, err := client.Get(ks.source)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: GET %s: %s", ks.source, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	return Parse(b)
+}
+
+// Key returns the key with the given id.
+func (ks *KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	if !ks.isURL() || time.Since(ks.refreshedAt) < REFRESH_INTERVAL {
+		return nil, ErrUnknownKey
+	}
+
+	ks.refreshedAt = time.Now()
+	keys, err := ks.load()
+	if err != nil {
+		return nil, err
+	}
+	ks.keys = keys
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
@@ -0,0 +1,51 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type JwksSuite struct {
+	suite.Suite
+}
+
+func TestJwks(t *testing.T) {
+	suite.Run(t, new(JwksSuite))
+}
+
+func (suite *JwksSuite) TestLoadFile() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().Nil(err)
+	n := base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes())
+	set := fmt.Sprintf(`{"keys": [
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": "%[1]s", "e": "%[2]s"},
+		{"kid": "ec", "kty": "EC", "use": "sig", "crv": "P-256"},
+		{"kid": "sig", "kty": "RSA", "use": "sig", "alg": "RS256", "n": "%[1]s", "e": "%[2]s"}
+	]}`, n, e)
+
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Require().Nil(os.WriteFile(path, []byte(set), 0600))
+	ks, err := Load(path)
+	suite.Require().Nil(err)
+
+	pub, err := ks.Key("sig")
+	suite.Require().Nil(err)
+	suite.True(key.PublicKey.Equal(pub))
+
+	_, err = ks.Key("enc")
+	suite.Equal(ErrUnknownKey, err)
+	_, err = ks.Key("ec")
+	suite.Equal(ErrUnknownKey, err)
+
+	_, err = Load(filepath.Join(suite.T().TempDir(), "missing.json"))
+	suite.NotNil(err)
+}
@@ -0,0 +1,25 @@
+package ksuidutil
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// KSUID timestamps are seconds since this epoch (see github.com/segmentio/ksuid).
+const KSUID_EPOCH = 1400000000
+
+var zeroPayload = make([]byte, 16)
+
+// LowerBound returns the smallest KSUID that could have been generated at t.
+// Every KSUID generated at or after t is greater or equal to it.
+func LowerBound(t time.Time) string {
+	if t.Unix() <= KSUID_EPOCH {
+		return ksuid.Nil.String()
+	}
+	id, err := ksuid.FromParts(t, zeroPayload)
+	if err != nil {
+		return ksuid.Nil.String()
+	}
+	return id.String()
+}
@@ -0,0 +1,29 @@
+package ksuidutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type KsuidSuite struct {
+	suite.Suite
+}
+
+func TestKsuid(t *testing.T) {
+	suite.Run(t, new(KsuidSuite))
+}
+
+func (suite *KsuidSuite) TestLowerBound() {
+	now := time.Now()
+	bound := LowerBound(now)
+	suite.True(ksuid.New().String() >= bound)
+
+	id, err := ksuid.NewRandomWithTime(now.Add(-time.Second))
+	suite.Require().Nil(err)
+	suite.True(id.String() < bound)
+
+	suite.Equal(ksuid.Nil.String(), LowerBound(time.Unix(0, 0)))
+}
@@ -0,0 +1,51 @@
+package signedtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	pkgerr "github.com/pkg/errors"
+)
+
+var ErrInvalid = errors.New("invalid token")
+
+// Sign encodes v as JSON and signs it with HMAC-SHA256.
+// The token is "<base64url payload>.<base64url signature>".
+func Sign(secret []byte, v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", pkgerr.Wrap(err, "json.Marshal")
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac(secret, encoded)), nil
+}
+
+// Verify checks the signature of the token and decodes its payload into v.
+func Verify(secret []byte, token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ErrInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, mac(secret, parts[0])) {
+		return ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalid
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func mac(secret []byte, encoded string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
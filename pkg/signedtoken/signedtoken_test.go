@@ -0,0 +1,33 @@
+package signedtoken
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SignedTokenSuite struct {
+	suite.Suite
+}
+
+func TestSignedToken(t *testing.T) {
+	suite.Run(t, new(SignedTokenSuite))
+}
+
+type payload struct {
+	Id string `json:"id"`
+}
+
+func (suite *SignedTokenSuite) TestSignVerify() {
+	secret := []byte("secret")
+	token, err := Sign(secret, payload{Id: "2rd"})
+	suite.Require().Nil(err)
+
+	var p payload
+	suite.Nil(Verify(secret, token, &p))
+	suite.Equal("2rd", p.Id)
+
+	suite.Equal(ErrInvalid, Verify([]byte("other"), token, &p))
+	suite.Equal(ErrInvalid, Verify(secret, "eyJpZCI6IjJyZSJ9."+token[len(token)-43:], &p))
+	suite.Equal(ErrInvalid, Verify(secret, "garbage", &p))
+}
@@ -0,0 +1,247 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pkgerr "github.com/pkg/errors"
+)
+
+// Every record is prefixed by a header of its length and CRC-32C checksum.
+const (
+	HEADER_SIZE     = 8
+	SEGMENT_EXT     = ".log"
+	CORRUPT_EXT     = ".corrupt"
+	MAX_RECORD_SIZE = 16 << 20
+)
+
+var (
+	ErrCorrupt = errors.New("spool: corrupt record")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Spool is an append-only log of records split into numbered segment files.
+// Records are fsync'd before Append returns, records of a failed Append are discarded. A new segment is started when
+// the active one exceeds the max segment size or when Rotate is called.
+// Only sealed (non active) segments are handed out for reading.
+type Spool struct {
+	dir            string
+	maxSegmentSize int64
+
+	mu         sync.Mutex
+	seq        uint64
+	active     *os.File
+	activeSize int64
+}
+
+func Open(dir string, maxSegmentSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, pkgerr.Wrap(err, "spool: mkdir")
+	}
+
+	s := &Spool{dir: dir, maxSegmentSize: maxSegmentSize}
+	segments, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	// Never append to segments of a previous run, they might end with a torn write.
+	if len(segments) > 0 {
+		s.seq = segments[len(segments)-1]
+	}
+	return s, nil
+}
+
+// Append writes all records to the active segment and syncs it to disk.
+func (s *Spool) Append(records [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(s.active)
+	header := make([]byte, HEADER_SIZE)
+	written := int64(0)
+	for _, record := range records {
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(record)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(record, crcTable))
+		if _, err := w.Write(header); err != nil {
+			return s.discard(pkgerr.Wrap(err, "spool: write header"))
+		}
+		if _, err := w.Write(record); err != nil {
+			return s.discard(pkgerr.Wrap(err, "spool: write record"))
+		}
+		written += int64(HEADER_SIZE + len(record))
+	}
+	if err := w.Flush(); err != nil {
+		return s.discard(pkgerr.Wrap(err, "spool: flush"))
+	}
+	if err := s.active.Sync(); err != nil {
+		return s.discard(pkgerr.Wrap(err, "spool: fsync"))
+	}
+
+	s.activeSize += written
+	if s.activeSize >= s.maxSegmentSize {
+		return s.closeSegment()
+	}
+	return nil
+}
+
+// Drops the records of a failed append by truncating the active segment back to its last
+// good size. If that fails too the segment is sealed, so that no record ever follows a torn one.
+func (s *Spool) discard(err error) error {
+	if terr := s.active.Truncate(s.activeSize); terr != nil {
+		s.closeSegment()
+	}
+	return err
+}
+
+// Rotate seals the active segment and returns the paths of all sealed segments, oldest first.
+func (s *Spool) Rotate() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		if err := s.closeSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	segments, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(segments))
+	for i, seq := range segments {
+		paths[i] = s.segmentPath(seq)
+	}
+	return paths, nil
+}
+
+// Remove deletes a fully consumed segment.
+func (s *Spool) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// Quarantine renames a corrupt segment so it is no longer read but kept for inspection.
+func (s *Spool) Quarantine(path string) error {
+	return os.Rename(path, strings.TrimSuffix(path, SEGMENT_EXT)+CORRUPT_EXT)
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	return s.closeSegment()
+}
+
+func (s *Spool) openSegment() error {
+	s.seq++
+	f, err := os.OpenFile(s.segmentPath(s.seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return pkgerr.Wrap(err, "spool: open segment")
+	}
+	// Make sure the new file itself survives a crash.
+	if dir, err := os.Open(s.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	s.active = f
+	s.activeSize = 0
+	return nil
+}
+
+func (s *Spool) closeSegment() error {
+	err := s.active.Close()
+	s.active = nil
+	s.activeSize = 0
+	if err != nil {
+		return pkgerr.Wrap(err, "spool: close segment")
+	}
+	return nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, SEGMENT_EXT))
+}
+
+// Lists sequence numbers of all segments in the directory, excluding the active one.
+func (s *Spool) list() ([]uint64, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "spool: read dir")
+	}
+	segments := []uint64{}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, SEGMENT_EXT) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, SEGMENT_EXT), 10, 64)
+		if err != nil {
+			continue
+		}
+		if s.active != nil && seq == s.seq {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// ReadSegment calls fn for every record in the segment in order.
+// A torn record at the end of the segment (crash while writing) is ignored.
+// Returns ErrCorrupt when a checksum does not match.
+func ReadSegment(path string, fn func(record []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return pkgerr.Wrap(err, "spool: open segment")
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, HEADER_SIZE)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return pkgerr.Wrap(err, "spool: read header")
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > MAX_RECORD_SIZE {
+			return ErrCorrupt
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return pkgerr.Wrap(err, "spool: read record")
+		}
+		if crc32.Checksum(record, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return ErrCorrupt
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
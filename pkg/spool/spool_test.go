@@ -0,0 +1,136 @@
+package spool
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SpoolSuite struct {
+	suite.Suite
+	dir string
+}
+
+func TestSpool(t *testing.T) {
+	suite.Run(t, new(SpoolSuite))
+}
+
+func (suite *SpoolSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "spool")
+	suite.Require().Nil(err)
+	suite.dir = dir
+}
+
+func (suite *SpoolSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *SpoolSuite) readAll(path string) ([]string, error) {
+	records := []string{}
+	err := ReadSegment(path, func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	})
+	return records, err
+}
+
+func (suite *SpoolSuite) TestAppendRotateRead() {
+	s, err := Open(suite.dir, 20)
+	suite.Require().Nil(err)
+
+	// First append exceeds the segment size and seals it.
+	suite.Nil(s.Append([][]byte{[]byte("first"), []byte("second")}))
+	suite.Nil(s.Append([][]byte{[]byte("third")}))
+
+	paths, err := s.Rotate()
+	suite.Require().Nil(err)
+	suite.Require().Equal(2, len(paths))
+
+	records, err := suite.readAll(paths[0])
+	suite.Nil(err)
+	suite.Equal([]string{"first", "second"}, records)
+	records, err = suite.readAll(paths[1])
+	suite.Nil(err)
+	suite.Equal([]string{"third"}, records)
+
+	suite.Nil(s.Remove(paths[0]))
+	suite.Nil(s.Close())
+
+	// Reopening continues numbering after existing segments.
+	s, err = Open(suite.dir, 20)
+	suite.Require().Nil(err)
+	suite.Nil(s.Append([][]byte{[]byte("fourth")}))
+	paths, err = s.Rotate()
+	suite.Nil(err)
+	suite.Equal(2, len(paths))
+	suite.Nil(s.Close())
+}
+
+func (suite *SpoolSuite) TestCorruptAndTorn() {
+	s, err := Open(suite.dir, 1<<20)
+	suite.Require().Nil(err)
+	suite.Nil(s.Append([][]byte{[]byte("record")}))
+	paths, err := s.Rotate()
+	suite.Require().Nil(err)
+	path := paths[0]
+
+	data, err := os.ReadFile(path)
+	suite.Require().Nil(err)
+
+	// Torn write at the end is ignored.
+	suite.Nil(os.WriteFile(path, append(data, 0, 0, 0), 0644))
+	records, err := suite.readAll(path)
+	suite.Nil(err)
+	suite.Equal([]string{"record"}, records)
+
+	// Flipped byte fails the checksum.
+	data[len(data)-1] ^= 0xff
+	suite.Nil(os.WriteFile(path, data, 0644))
+	_, err = suite.readAll(path)
+	suite.Equal(ErrCorrupt, err)
+
+	suite.Nil(s.Quarantine(path))
+	paths, err = s.Rotate()
+	suite.Nil(err)
+	suite.Equal(0, len(paths))
+}
+
+func (suite *SpoolSuite) TestFailedAppend() {
+	s, err := Open(suite.dir, 1<<20)
+	suite.Require().Nil(err)
+	suite.Nil(s.Append([][]byte{[]byte("first")}))
+	path := s.segmentPath(s.seq)
+
+	// A partially written batch is truncated away.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	suite.Require().Nil(err)
+	_, err = f.Write([]byte{0, 0, 0})
+	suite.Require().Nil(err)
+	suite.Require().Nil(f.Close())
+	suite.EqualError(s.discard(os.ErrClosed), os.ErrClosed.Error())
+	suite.Nil(s.Append([][]byte{[]byte("second")}))
+	records, err := suite.readAll(path)
+	suite.Nil(err)
+	suite.Equal([]string{"first", "second"}, records)
+
+	// The segment is sealed when it can't be truncated either.
+	active := s.active
+	s.active, err = os.Open(path)
+	suite.Require().Nil(err)
+	suite.Require().Nil(active.Close())
+	suite.NotNil(s.Append([][]byte{[]byte("third")}))
+	suite.Nil(s.active)
+	suite.Nil(s.Append([][]byte{[]byte("fourth")}))
+
+	paths, err := s.Rotate()
+	suite.Require().Nil(err)
+	suite.Require().Equal(2, len(paths))
+	records, err = suite.readAll(paths[0])
+	suite.Nil(err)
+	suite.Equal([]string{"first", "second"}, records)
+	records, err = suite.readAll(paths[1])
+	suite.Nil(err)
+	suite.Equal([]string{"fourth"}, records)
+	suite.Nil(s.Close())
+}
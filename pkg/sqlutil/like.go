@@ -0,0 +1,19 @@
+package sqlutil
+
+import "strings"
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// LikePrefix returns a LIKE pattern matching strings starting with prefix.
+func LikePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// LikePrefixes returns LIKE patterns of the prefixes, as in LIKE ANY.
+func LikePrefixes(prefixes []string) []string {
+	patterns := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		patterns[i] = LikePrefix(prefix)
+	}
+	return patterns
+}
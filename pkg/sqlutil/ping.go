@@ -0,0 +1,19 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Ping checks the DB is reachable within the given timeout.
+func Ping(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+
+	err := db.PingContext(ctx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error classes worth retrying: connection exception, transaction rollback
+// (serialization failures, deadlocks), insufficient resources, operator intervention
+// (e.g., admin shutdown) and system error.
+var transientClasses = map[pq.ErrorClass]bool{
+	"08": true,
+	"40": true,
+	"53": true,
+	"57": true,
+	"58": true,
+}
+
+// IsTransient tells whether err is a DB error that may pass when retried later, such as
+// a lost connection, as opposed to a permanent one like a constraint violation.
+func IsTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientClasses[pqErr.Code.Class()]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
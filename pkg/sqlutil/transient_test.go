@@ -0,0 +1,32 @@
+package sqlutil
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+type TransientSuite struct {
+	suite.Suite
+}
+
+func TestTransient(t *testing.T) {
+	suite.Run(t, new(TransientSuite))
+}
+
+func (suite *TransientSuite) TestIsTransient() {
+	suite.True(IsTransient(&pq.Error{Code: "08006"}))
+	suite.True(IsTransient(&pq.Error{Code: "40001"}))
+	suite.True(IsTransient(&pq.Error{Code: "57P01"}))
+	suite.True(IsTransient(pkgerr.WithStack(WrappingTxError(driver.ErrBadConn, "begin tx"))))
+	suite.True(IsTransient(pkgerr.Wrap(&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "insert")))
+
+	suite.False(IsTransient(&pq.Error{Code: "23505"}))
+	suite.False(IsTransient(pkgerr.Wrap(&pq.Error{Code: "22P02"}, "insert")))
+	suite.False(IsTransient(errors.New("json: unsupported value")))
+}
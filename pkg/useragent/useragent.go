@@ -0,0 +1,105 @@
+// Package useragent classifies User-Agent headers by browser, OS, device class
+// and whether they're bots, see github.com/mssola/user_agent.
+package useragent
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mssola/user_agent"
+)
+
+// Device classes.
+const (
+	DEVICE_DESKTOP = "desktop"
+	DEVICE_MOBILE  = "mobile"
+	DEVICE_TABLET  = "tablet"
+	DEVICE_BOT     = "bot"
+	// Empty or unrecognized user agents.
+	DEVICE_OTHER = "other"
+)
+
+// Bounds the memory of parsed user agents, the cache is reset when full.
+const MAX_CACHED = 10000
+
+// Tokens of automated clients not reported as bots by the parser, lower cased.
+var botTokens = []string{"headlesschrome", "curl/", "wget/", "python-requests/", "go-http-client/", "spider", "crawler"}
+
+// Info of a user agent, Browser and OS are empty when unknown.
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+	Bot     bool
+}
+
+var (
+	mu    sync.Mutex
+	cache = map[string]Info{}
+)
+
+// Parse classifies the user agent, results are cached as user agents repeat a lot.
+func Parse(ua string) Info {
+	mu.Lock()
+	info, ok := cache[ua]
+	mu.Unlock()
+	if ok {
+		return info
+	}
+
+	info = parse(ua)
+	mu.Lock()
+	if len(cache) >= MAX_CACHED {
+		cache = map[string]Info{}
+	}
+	cache[ua] = info
+	mu.Unlock()
+	return info
+}
+
+func parse(ua string) Info {
+	parsed := user_agent.New(ua)
+	info := Info{OS: osName(parsed)}
+	info.Browser, _ = parsed.Browser()
+	info.Bot = parsed.Bot() || isBot(ua)
+
+	platform := parsed.Platform()
+	switch {
+	case info.Bot:
+		info.Device = DEVICE_BOT
+	case ua == "":
+		info.Device = DEVICE_OTHER
+	case platform == "iPad" || strings.Contains(ua, "Tablet") ||
+		(info.OS == "Android" && !strings.Contains(ua, "Mobile")):
+		info.Device = DEVICE_TABLET
+	case parsed.Mobile():
+		info.Device = DEVICE_MOBILE
+	case platform == "Windows" || platform == "Macintosh" || platform == "X11" || info.OS != "":
+		info.Device = DEVICE_DESKTOP
+	default:
+		info.Device = DEVICE_OTHER
+	}
+	return info
+}
+
+// Names iOS and macOS by their current names, the parser reports iPad OS as "OS".
+func osName(parsed *user_agent.UserAgent) string {
+	name := parsed.OSInfo().Name
+	switch {
+	case parsed.Platform() == "iPhone" || parsed.Platform() == "iPad" || parsed.Platform() == "iPod":
+		return "iOS"
+	case name == "Mac OS X":
+		return "macOS"
+	}
+	return name
+}
+
+func isBot(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type UserAgentSuite struct {
+	suite.Suite
+}
+
+func TestUserAgent(t *testing.T) {
+	suite.Run(t, new(UserAgentSuite))
+}
+
+func (suite *UserAgentSuite) TestParse() {
+	for ua, expected := range map[string]Info{
+		"": {Device: DEVICE_OTHER},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36": {
+			Browser: "Chrome", OS: "Windows", Device: DEVICE_DESKTOP},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46": {
+			Browser: "Edge", OS: "Windows", Device: DEVICE_DESKTOP},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15": {
+			Browser: "Safari", OS: "macOS", Device: DEVICE_DESKTOP},
+		"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0": {
+			Browser: "Firefox", OS: "Linux", Device: DEVICE_DESKTOP},
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1": {
+			Browser: "Safari", OS: "iOS", Device: DEVICE_MOBILE},
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36": {
+			Browser: "Chrome", OS: "Android", Device: DEVICE_MOBILE},
+		"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1": {
+			Browser: "Safari", OS: "iOS", Device: DEVICE_TABLET},
+		"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36": {
+			Browser: "Chrome", OS: "Android", Device: DEVICE_TABLET},
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": {
+			Browser: "Googlebot", Device: DEVICE_BOT, Bot: true},
+		"curl/7.64.1": {Browser: "curl", Device: DEVICE_BOT, Bot: true},
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/118.0.0.0 Safari/537.36": {
+			Browser: "Headless Chrome", OS: "Linux", Device: DEVICE_BOT, Bot: true},
+	} {
+		info := Parse(ua)
+		suite.Equal(expected, info, ua)
+		suite.Equal(info, Parse(ua), "cached %s", ua)
+	}
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Full buckets are removed at most this often.
+const SWEEP_INTERVAL = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.limit.Rate
+		if b.tokens > b.limit.Burst {
+			b.tokens = b.limit.Burst
+		}
+		b.updated = now
+	}
+}
+
+// MemoryStore keeps the buckets of a single replica.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(key string, n float64, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= SWEEP_INTERVAL {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.Burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+	if b.tokens < n {
+		return false, limit.refill(n - b.tokens), nil
+	}
+	b.tokens -= n
+	return true, 0, nil
+}
+
+// Full buckets are the same as missing ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= b.limit.Burst {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
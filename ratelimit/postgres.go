@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+)
+
+// Tokens available in bucket b, refilled up to burst with rate tokens per second.
+func available(burst, rate string) string {
+	return fmt.Sprintf("LEAST(%s, b.tokens + GREATEST(EXTRACT(EPOCH FROM now() - b.updated_at)::float8, 0) * %s)", burst, rate)
+}
+
+// Takes $3 tokens of bucket $1 if available, leaving the bucket as is otherwise.
+// $2 is the burst and $4 the rate.
+var takeQuery = fmt.Sprintf(`INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, full_at)
+VALUES ($1, $2::float8 - $3::float8, now(), now() + $3::float8 / $4::float8 * interval '1 second')
+ON CONFLICT (key) DO UPDATE SET
+  tokens = %[1]s - $3::float8,
+  updated_at = now(),
+  full_at = now() + ($2::float8 - (%[1]s - $3::float8)) / $4::float8 * interval '1 second'
+WHERE %[1]s >= $3::float8
+RETURNING tokens`, available("$2::float8", "$4::float8"))
+
+var availableQuery = fmt.Sprintf("SELECT %s FROM rate_limit_buckets AS b WHERE key = $1", available("$2::float8", "$3::float8"))
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, shared by replicas.
+// Buckets are refilled by the DB clock so replicas' clocks don't matter.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(key string, n float64, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.maybeSweep(now)
+
+	var tokens float64
+	err := s.db.QueryRow(takeQuery, key, limit.Burst, n, limit.Rate).Scan(&tokens)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, pkgerr.Wrap(err, "take tokens")
+	}
+
+	// Not enough tokens, the bucket wasn't updated.
+	if err := s.db.QueryRow(availableQuery, key, limit.Burst, limit.Rate).Scan(&tokens); err != nil {
+		return false, 0, pkgerr.Wrap(err, "select available tokens")
+	}
+	if tokens >= n {
+		// Refilled since.
+		return false, 0, nil
+	}
+	return false, limit.refill(n - tokens), nil
+}
+
+// Deletes full buckets, at most every SWEEP_INTERVAL per replica.
+func (s *PostgresStore) maybeSweep(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < SWEEP_INTERVAL {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	go s.db.Exec("DELETE FROM rate_limit_buckets WHERE full_at < now()")
+}
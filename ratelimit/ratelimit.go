@@ -0,0 +1,174 @@
+// Package ratelimit limits appends with token buckets keyed by client IP,
+// user and namespace. Buckets are kept by a Store, in memory by default or in
+// Postgres for replicas to share them.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Kinds of rate limited keys.
+const (
+	IP        = "ip"
+	USER      = "user"
+	NAMESPACE = "namespace"
+)
+
+// Keys tracked for dropped events, the rest are counted as OTHER_KEY.
+const (
+	MAX_TRACKED_KEYS = 1000
+	OTHER_KEY        = "other"
+)
+
+// Limit of a token bucket holding up to Burst tokens and refilled with Rate tokens per second.
+// A zero Rate is unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Time to refill n tokens.
+func (l Limit) refill(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / l.Rate * float64(time.Second)))
+}
+
+// Config of the limits, IP limits requests while user and namespace limit events.
+type Config struct {
+	IP        Limit `json:"ip"`
+	User      Limit `json:"user"`
+	Namespace Limit `json:"namespace"`
+	// Limits of specific namespaces, overriding Namespace.
+	Namespaces map[string]Limit `json:"namespaces"`
+}
+
+// ParseConfig parses a JSON config, e.g.,
+// {"ip": {"rate": 50, "burst": 100}, "namespaces": {"archive": {"rate": 1000, "burst": 5000}}}.
+// Empty is unlimited.
+func ParseConfig(s string) (Config, error) {
+	c := Config{}
+	if s == "" {
+		return c, nil
+	}
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return c, fmt.Errorf("invalid rate limits: %w", err)
+	}
+	limits := []Limit{c.IP, c.User, c.Namespace}
+	for _, l := range c.Namespaces {
+		limits = append(limits, l)
+	}
+	for _, l := range limits {
+		if !l.Unlimited() && l.Burst < 1 {
+			return c, fmt.Errorf("expected burst of at least 1 with rate %g", l.Rate)
+		}
+	}
+	return c, nil
+}
+
+func (c Config) limit(kind, value string) Limit {
+	switch kind {
+	case IP:
+		return c.IP
+	case USER:
+		return c.User
+	case NAMESPACE:
+		if l, ok := c.Namespaces[value]; ok {
+			return l
+		}
+		return c.Namespace
+	}
+	return Limit{}
+}
+
+// Store takes tokens from buckets, creating full ones as needed.
+type Store interface {
+	// Take takes n tokens from the bucket of the key if it holds them, otherwise
+	// it returns how long until it does.
+	Take(key string, n float64, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// Limiter takes tokens from the store and counts dropped events per key.
+type Limiter struct {
+	store  Store
+	config Config
+	log    zerolog.Logger
+
+	mu      sync.Mutex
+	dropped map[string]int64
+	since   time.Time
+}
+
+func NewLimiter(store Store, config Config, log zerolog.Logger) *Limiter {
+	return &Limiter{
+		store:   store,
+		config:  config,
+		log:     log,
+		dropped: map[string]int64{},
+		since:   time.Now(),
+	}
+}
+
+// Allow takes n tokens of the kind's value, e.g., of user "abc". When not allowed it
+// returns how long to wait before retrying. Store errors allow the events.
+func (l *Limiter) Allow(kind, value string, n int) (bool, time.Duration) {
+	limit := l.config.limit(kind, value)
+	if limit.Unlimited() {
+		return true, 0
+	}
+	key := kind + ":" + value
+	if float64(n) > limit.Burst {
+		// Never allowed at once, retry when the bucket is full.
+		l.drop(key, n)
+		return false, limit.refill(limit.Burst)
+	}
+	ok, retryAfter, err := l.store.Take(key, float64(n), limit, time.Now())
+	if err != nil {
+		l.log.Warn().Err(err).Str("key", key).Msg("Rate limit store, allowing")
+		return true, 0
+	}
+	if !ok {
+		l.drop(key, n)
+	}
+	return ok, retryAfter
+}
+
+func (l *Limiter) drop(key string, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.dropped[key]; !ok && len(l.dropped) >= MAX_TRACKED_KEYS {
+		key = OTHER_KEY
+	}
+	l.dropped[key] += int64(n)
+}
+
+type Dropped struct {
+	Key    string `json:"key"`
+	Events int64  `json:"events"`
+}
+
+// Dropped returns the events dropped per key since the limiter started, most dropped first.
+func (l *Limiter) Dropped() ([]Dropped, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := make([]Dropped, 0, len(l.dropped))
+	for key, events := range l.dropped {
+		dropped = append(dropped, Dropped{Key: key, Events: events})
+	}
+	sort.Slice(dropped, func(i, j int) bool {
+		if dropped[i].Events != dropped[j].Events {
+			return dropped[i].Events > dropped[j].Events
+		}
+		return dropped[i].Key < dropped[j].Key
+	})
+	return dropped, l.since
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitSuite struct {
+	suite.Suite
+}
+
+func TestRateLimit(t *testing.T) {
+	suite.Run(t, new(RateLimitSuite))
+}
+
+func (suite *RateLimitSuite) TestParseConfig() {
+	c, err := ParseConfig("")
+	suite.Require().Nil(err)
+	suite.True(c.limit(IP, "10.0.0.1").Unlimited())
+
+	c, err = ParseConfig(`{"user": {"rate": 10, "burst": 20}, "namespace": {"rate": 100, "burst": 100}, "namespaces": {"archive": {"rate": 1, "burst": 1}}}`)
+	suite.Require().Nil(err)
+	suite.True(c.limit(IP, "10.0.0.1").Unlimited())
+	suite.Equal(Limit{Rate: 10, Burst: 20}, c.limit(USER, "abc"))
+	suite.Equal(Limit{Rate: 1, Burst: 1}, c.limit(NAMESPACE, "archive"))
+	suite.Equal(Limit{Rate: 100, Burst: 100}, c.limit(NAMESPACE, "kmedia"))
+
+	_, err = ParseConfig(`{"ip": {"rate": 10}}`)
+	suite.NotNil(err)
+	_, err = ParseConfig(`{"ip": 10}`)
+	suite.NotNil(err)
+}
+
+func (suite *RateLimitSuite) TestMemoryStore() {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 2, Burst: 4}
+	now := time.Now()
+
+	ok, _, err := s.Take("k", 3, limit, now)
+	suite.Require().Nil(err)
+	suite.True(ok)
+	ok, retryAfter, err := s.Take("k", 2, limit, now)
+	suite.Require().Nil(err)
+	suite.False(ok)
+	suite.Equal(500*time.Millisecond, retryAfter)
+
+	// Refilled at 2 tokens per second, up to the burst.
+	ok, _, _ = s.Take("k", 2, limit, now.Add(500*time.Millisecond))
+	suite.True(ok)
+	ok, _, _ = s.Take("k", 4, limit, now.Add(time.Hour))
+	suite.True(ok)
+
+	// Other keys have their own bucket.
+	ok, _, _ = s.Take("other", 4, limit, now.Add(time.Hour))
+	suite.True(ok)
+
+	// Full buckets are swept.
+	s.Take("new", 1, limit, now.Add(2*time.Hour))
+	suite.Len(s.buckets, 1)
+}
+
+func (suite *RateLimitSuite) TestLimiter() {
+	config := Config{
+		User:       Limit{Rate: 1, Burst: 2},
+		Namespaces: map[string]Limit{"archive": {Rate: 1, Burst: 1}},
+	}
+	l := NewLimiter(NewMemoryStore(), config, zerolog.Nop())
+
+	ok, _ := l.Allow(IP, "10.0.0.1", 100)
+	suite.True(ok)
+	ok, _ = l.Allow(NAMESPACE, "kmedia", 100)
+	suite.True(ok)
+
+	ok, _ = l.Allow(USER, "abc", 2)
+	suite.True(ok)
+	ok, retryAfter := l.Allow(USER, "abc", 1)
+	suite.False(ok)
+	suite.True(retryAfter > 0 && retryAfter <= time.Second)
+
+	// More than the burst is never allowed.
+	ok, retryAfter = l.Allow(NAMESPACE, "archive", 3)
+	suite.False(ok)
+	suite.Equal(time.Second, retryAfter)
+
+	dropped, _ := l.Dropped()
+	suite.Equal([]Dropped{{Key: "namespace:archive", Events: 3}, {Key: "user:abc", Events: 1}}, dropped)
+}
+
+func (suite *RateLimitSuite) TestDroppedKeysBounded() {
+	l := NewLimiter(NewMemoryStore(), Config{User: Limit{Rate: 1, Burst: 1}}, zerolog.Nop())
+	for i := 0; i < MAX_TRACKED_KEYS+10; i++ {
+		l.Allow(USER, fmt.Sprintf("u%d", i), 2)
+	}
+	dropped, _ := l.Dropped()
+	suite.Len(dropped, MAX_TRACKED_KEYS+1)
+	suite.Equal(Dropped{Key: OTHER_KEY, Events: 20}, dropped[0])
+}
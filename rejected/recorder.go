@@ -0,0 +1,105 @@
+package rejected
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Expired entries are purged this often.
+const PURGE_INTERVAL = time.Hour
+
+// Rejected entries waiting to be stored, more are dropped.
+const QUEUE_SIZE = 1000
+
+// Recorder stores rejected entries in the background and purges them after ttl.
+// Storing is best effort, entries are dropped when the queue is full and failures
+// are only logged.
+type Recorder struct {
+	// First to be 64-bit aligned for atomic operations.
+	dropped int64
+
+	db  *sql.DB
+	ttl time.Duration
+	log zerolog.Logger
+
+	queue chan *Entry
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewRecorder(db *sql.DB, ttl time.Duration, log zerolog.Logger) *Recorder {
+	return &Recorder{
+		db:    db,
+		ttl:   ttl,
+		log:   log,
+		queue: make(chan *Entry, QUEUE_SIZE),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Record queues the entry to be stored, without waiting for the DB.
+func (r *Recorder) Record(e *Entry) {
+	select {
+	case r.queue <- e:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+// Start stores queued entries and purges expired ones in the background until Stop is called.
+// Entries still queued on Stop are stored before it returns.
+func (r *Recorder) Start() {
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(PURGE_INTERVAL)
+		defer ticker.Stop()
+		r.purge()
+		for {
+			select {
+			case e := <-r.queue:
+				r.store(e)
+			case <-ticker.C:
+				r.purge()
+			case <-r.stop:
+				for {
+					select {
+					case e := <-r.queue:
+						r.store(e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (r *Recorder) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Recorder) store(e *Entry) {
+	if err := Insert(r.db, e); err != nil {
+		r.log.Error().Err(err).Msg("Failed storing rejected entry")
+	}
+	if n := atomic.SwapInt64(&r.dropped, 0); n > 0 {
+		r.log.Warn().Msgf("Dropped %d rejected entries, the queue was full", n)
+	}
+}
+
+func (r *Recorder) purge() {
+	n, err := Purge(r.db, time.Now().Add(-r.ttl))
+	if err != nil {
+		r.log.Error().Err(err).Msg("Failed purging rejected entries")
+		return
+	}
+	if n > 0 {
+		r.log.Info().Msgf("Purged %d rejected entries older than %s", n, r.ttl)
+	}
+}
@@ -0,0 +1,26 @@
+package rejected
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+)
+
+type RecorderSuite struct {
+	suite.Suite
+}
+
+func TestRecorder(t *testing.T) {
+	suite.Run(t, new(RecorderSuite))
+}
+
+func (suite *RecorderSuite) TestRecordDropsWhenFull() {
+	r := NewRecorder(nil, time.Hour, zerolog.Nop())
+	for i := 0; i < QUEUE_SIZE+5; i++ {
+		r.Record(&Entry{})
+	}
+	suite.Len(r.queue, QUEUE_SIZE)
+	suite.Equal(int64(5), r.dropped)
+}
@@ -0,0 +1,173 @@
+// Package rejected keeps appends rejected by validation, the dead letters of
+// ingestion. Rejected bodies are stored as received, with the reason and the
+// client's IP and user agent, for REJECTED_TTL. They can be replayed once the
+// client, or the event schema, is fixed.
+package rejected
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+const (
+	ENDPOINT_APPEND  = "/append"
+	ENDPOINT_APPENDS = "/appends"
+	// Accepted entries failing to be written, the body is the entry.
+	ENDPOINT_INGEST = "ingest"
+
+	// Longer bodies are truncated, they are kept for debugging but can't be replayed.
+	MAX_BODY_SIZE = 64 << 10
+
+	MAX_LIMIT = 1000
+)
+
+var ErrNotFound = errors.New("rejected entry not found")
+
+type Entry struct {
+	ID        string    `boil:"id" json:"id"`
+	CreatedAt time.Time `boil:"created_at" json:"created_at"`
+
+	Endpoint string `boil:"endpoint" json:"endpoint"`
+	// Index in the /appends request, the body is then of the single append request.
+	BatchIndex      null.Int `boil:"batch_index" json:"batch_index"`
+	Namespace       string   `boil:"namespace" json:"namespace"`
+	ClientEventType string   `boil:"client_event_type" json:"client_event_type"`
+	Reason          string   `boil:"reason" json:"reason"`
+	Body            string   `boil:"body" json:"body"`
+
+	IPAddr          string      `boil:"ip_addr" json:"ip_addr"`
+	UserAgent       string      `boil:"user_agent" json:"user_agent"`
+	KeycloakSubject null.String `boil:"keycloak_subject" json:"keycloak_subject"`
+	APIKeyID        null.String `boil:"api_key_id" json:"api_key_id"`
+
+	ReplayedAt null.Time `boil:"replayed_at" json:"replayed_at"`
+}
+
+// Filter of Scan, zero values match all.
+type Filter struct {
+	Namespaces       []string
+	ClientEventTypes []string
+	From             time.Time
+	To               time.Time
+	Replayed         null.Bool
+	// Id of the last entry of the previous page.
+	After string
+	Limit int
+}
+
+// Body returns the body to store, truncated to MAX_BODY_SIZE and valid UTF-8.
+func Body(raw []byte) string {
+	if len(raw) > MAX_BODY_SIZE {
+		raw = raw[:MAX_BODY_SIZE]
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return strings.ToValidUTF8(string(raw), "�")
+}
+
+// Insert stores the entry, setting its id by its creation time.
+func Insert(exec boil.Executor, e *Entry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	id, err := ksuid.NewRandomWithTime(e.CreatedAt)
+	if err != nil {
+		return pkgerr.Wrap(err, "new ksuid")
+	}
+	e.ID = id.String()
+	_, err = exec.Exec(`INSERT INTO rejected_entries (id, created_at, endpoint, batch_index, namespace, client_event_type,
+reason, body, ip_addr, user_agent, keycloak_subject, api_key_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		e.ID, e.CreatedAt, e.Endpoint, e.BatchIndex, e.Namespace, e.ClientEventType,
+		e.Reason, e.Body, e.IPAddr, e.UserAgent, e.KeycloakSubject, e.APIKeyID)
+	return pkgerr.Wrap(err, "insert rejected entry")
+}
+
+// Find returns the entry of the id, ErrNotFound if there's none.
+func Find(exec boil.Executor, id string) (*Entry, error) {
+	e := &Entry{}
+	if err := queries.Raw("SELECT * FROM rejected_entries WHERE id = $1", id).Bind(nil, exec, e); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, pkgerr.Wrap(err, "select rejected entry")
+	}
+	return e, nil
+}
+
+// Scan returns entries matching the filter by id, i.e., oldest first.
+func Scan(exec boil.Executor, f Filter) ([]*Entry, error) {
+	query, args := scanQuery(f)
+	entries := []*Entry{}
+	if err := queries.Raw(query, args...).Bind(nil, exec, &entries); err != nil {
+		return nil, pkgerr.Wrap(err, "select rejected entries")
+	}
+	return entries, nil
+}
+
+func scanQuery(f Filter) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	if len(f.Namespaces) > 0 {
+		add("namespace = ANY($%d)", pq.Array(f.Namespaces))
+	}
+	if len(f.ClientEventTypes) > 0 {
+		add("client_event_type = ANY($%d)", pq.Array(f.ClientEventTypes))
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.After != "" {
+		add("id > $%d", f.After)
+	}
+	if f.Replayed.Valid {
+		if f.Replayed.Bool {
+			where = append(where, "replayed_at IS NOT NULL")
+		} else {
+			where = append(where, "replayed_at IS NULL")
+		}
+	}
+
+	query := "SELECT * FROM rejected_entries"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > MAX_LIMIT {
+		limit = MAX_LIMIT
+	}
+	return fmt.Sprintf("%s ORDER BY id LIMIT %d", query, limit), args
+}
+
+// MarkReplayed sets replayed_at of the entry.
+func MarkReplayed(exec boil.Executor, id string, at time.Time) error {
+	_, err := exec.Exec("UPDATE rejected_entries SET replayed_at = $2 WHERE id = $1", id, at)
+	return pkgerr.Wrap(err, "update rejected entry")
+}
+
+// Purge deletes entries created before the time, returns how many.
+func Purge(exec boil.Executor, before time.Time) (int64, error) {
+	res, err := exec.Exec("DELETE FROM rejected_entries WHERE created_at < $1", before)
+	if err != nil {
+		return 0, pkgerr.Wrap(err, "delete rejected entries")
+	}
+	return res.RowsAffected()
+}
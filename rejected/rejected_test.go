@@ -0,0 +1,42 @@
+package rejected
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+type RejectedSuite struct {
+	suite.Suite
+}
+
+func TestRejected(t *testing.T) {
+	suite.Run(t, new(RejectedSuite))
+}
+
+func (suite *RejectedSuite) TestBody() {
+	suite.Equal(`{"namespace": "archive"}`, Body([]byte(`{"namespace": "archive"}`)))
+	suite.Equal("a�b", Body([]byte("a\xffb")))
+	suite.Len(Body([]byte(strings.Repeat("a", MAX_BODY_SIZE+10))), MAX_BODY_SIZE)
+}
+
+func (suite *RejectedSuite) TestScanQuery() {
+	query, args := scanQuery(Filter{})
+	suite.Equal("SELECT * FROM rejected_entries ORDER BY id LIMIT 1000", query)
+	suite.Empty(args)
+
+	from := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	query, args = scanQuery(Filter{
+		Namespaces: []string{"archive"},
+		From:       from,
+		Replayed:   null.BoolFrom(false),
+		After:      "2EHqDQdm3ZHTHWw8jGqzjcvBg1P",
+		Limit:      10,
+	})
+	suite.Equal("SELECT * FROM rejected_entries WHERE namespace = ANY($1) AND created_at >= $2 AND id > $3 AND replayed_at IS NULL ORDER BY id LIMIT 10", query)
+	suite.Len(args, 3)
+	suite.Equal(from, args[1])
+}
@@ -0,0 +1,55 @@
+package rollup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type firstSeenKey struct {
+	namespace string
+	userID    string
+}
+
+// Keeps the earliest created_at of every (namespace, user_id) of the batch.
+func updateFirstSeen(exec boil.Executor, entries models.EntrySlice) error {
+	firstSeen := make(map[firstSeenKey]time.Time)
+	keys := []firstSeenKey{}
+	for _, e := range entries {
+		key := firstSeenKey{namespace: e.Namespace, userID: e.UserID}
+		t, ok := firstSeen[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || e.CreatedAt.Before(t) {
+			firstSeen[key] = e.CreatedAt
+		}
+	}
+
+	for start := 0; start < len(keys); start += UPSERT_CHUNK_SIZE {
+		end := start + UPSERT_CHUNK_SIZE
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var b strings.Builder
+		args := make([]interface{}, 0, 3*(end-start))
+		b.WriteString("INSERT INTO first_seen (namespace, user_id, first_seen_at) VALUES ")
+		for i, key := range keys[start:end] {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "($%d,$%d,$%d)", len(args)+1, len(args)+2, len(args)+3)
+			args = append(args, key.namespace, key.userID, firstSeen[key])
+		}
+		b.WriteString(" ON CONFLICT (namespace, user_id) DO UPDATE SET first_seen_at = LEAST(first_seen.first_seen_at, EXCLUDED.first_seen_at)")
+		if _, err := exec.Exec(b.String(), args...); err != nil {
+			return pkgerr.Wrap(err, "upsert first seen")
+		}
+	}
+	return nil
+}
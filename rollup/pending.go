@@ -0,0 +1,73 @@
+package rollup
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/ksuidutil"
+)
+
+// Entries with ids older than this when written are queued in rollup_pending, they might be
+// behind the watermarks already. Jobs leave at least MIN_LAG of entries for the next run so
+// entries not queued are always ahead of the watermarks when committed. Entries created
+// (see offsets of appends) longer ago than this are queued as well, their days might be
+// reported as covered already, see CoveredUntil.
+const (
+	LATE_ENTRY_AGE = time.Minute
+	MIN_LAG        = 2 * LATE_ENTRY_AGE
+)
+
+// Late returns the ids of the written entries whose id or created_at is older than LATE_ENTRY_AGE.
+func Late(entries []*models.Entry, now time.Time) []string {
+	since := now.Add(-LATE_ENTRY_AGE)
+	bound := ksuidutil.LowerBound(since)
+	late := []string(nil)
+	for _, e := range entries {
+		if e.ID < bound || e.CreatedAt.Before(since) {
+			late = append(late, e.ID)
+		}
+	}
+	return late
+}
+
+// EnqueueLate queues the late entries among the newly written ones for all jobs.
+// Pass the transaction writing the entries so they are queued atomically.
+func EnqueueLate(exec boil.Executor, entries []*models.Entry, now time.Time) error {
+	late := Late(entries, now)
+	if len(late) == 0 {
+		return nil
+	}
+	_, err := exec.Exec(`INSERT INTO rollup_pending (name, entry_id)
+SELECT s.name, e.id FROM rollup_state s, unnest($1::text[]) AS e (id)
+ON CONFLICT DO NOTHING`, pq.Array(late))
+	return pkgerr.Wrap(err, "insert rollup pending")
+}
+
+// Ids of queued entries the watermark already passed, entries ahead of it are left to it.
+func pendingIDs(exec boil.Executor, name, lastID string, limit int) ([]string, error) {
+	rows, err := exec.Query(`SELECT entry_id FROM rollup_pending WHERE name = $1 AND entry_id <= $2
+ORDER BY entry_id LIMIT $3`, name, lastID, limit)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "select rollup pending")
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, pkgerr.Wrap(err, "scan rollup pending")
+		}
+		ids = append(ids, id)
+	}
+	return ids, pkgerr.Wrap(rows.Err(), "iterate rollup pending")
+}
+
+// Dequeues processed entries of the job.
+func dequeue(exec boil.Executor, name string, ids []string) error {
+	_, err := exec.Exec("DELETE FROM rollup_pending WHERE name = $1 AND entry_id = ANY($2::text[])", name, pq.Array(ids))
+	return pkgerr.Wrap(err, "delete rollup pending")
+}
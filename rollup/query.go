@@ -0,0 +1,87 @@
+package rollup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+type Filter struct {
+	// Days in [From, To).
+	From time.Time
+	To   time.Time
+
+	Namespaces       []string
+	ClientEventTypes []string
+	// Namespaces starting with any of the prefixes.
+	NamespacePrefixes []string
+}
+
+// Load reads the stored rollups matching the filter.
+func Load(exec boil.Executor, f Filter) ([]*Rollup, error) {
+	var b strings.Builder
+	b.WriteString("SELECT * FROM daily_rollups WHERE day >= $1::date AND day < $2::date")
+	args := []interface{}{f.From, f.To}
+	if len(f.Namespaces) > 0 {
+		args = append(args, pq.Array(f.Namespaces))
+		fmt.Fprintf(&b, " AND namespace = ANY($%d)", len(args))
+	}
+	if len(f.NamespacePrefixes) > 0 {
+		args = append(args, pq.Array(sqlutil.LikePrefixes(f.NamespacePrefixes)))
+		fmt.Fprintf(&b, " AND namespace LIKE ANY($%d)", len(args))
+	}
+	if len(f.ClientEventTypes) > 0 {
+		args = append(args, pq.Array(f.ClientEventTypes))
+		fmt.Fprintf(&b, " AND client_event_type = ANY($%d)", len(args))
+	}
+	b.WriteString(" ORDER BY day, namespace, client_event_type")
+
+	var rows []*rollupRow
+	if err := queries.Raw(b.String(), args...).Bind(nil, exec, &rows); err != nil {
+		return nil, pkgerr.Wrap(err, "select daily rollups")
+	}
+	rollups := make([]*Rollup, len(rows))
+	for i, row := range rows {
+		r, err := row.rollup()
+		if err != nil {
+			return nil, pkgerr.Wrapf(err, "decode daily rollup %s %s %s", row.Day.Format("2006-01-02"), row.Namespace, row.ClientEventType)
+		}
+		rollups[i] = r
+	}
+	return rollups, nil
+}
+
+// CoveredUntil returns the time before which all entries were processed by the job.
+// Entries not queued in rollup_pending are created at most LATE_ENTRY_AGE before their id,
+// queued ones bound it by their created_at until processed.
+func (j *Job) CoveredUntil(exec boil.Executor) (time.Time, error) {
+	var lastID string
+	var pending null.Time
+	err := exec.QueryRow(`SELECT s.last_id, (SELECT min(e.created_at) FROM rollup_pending AS p
+  JOIN entries AS e ON e.id = p.entry_id WHERE p.name = s.name)
+FROM rollup_state AS s WHERE s.name = $1`, j.Name).Scan(&lastID, &pending)
+	if err != nil {
+		return time.Time{}, pkgerr.Wrapf(err, "select %s state", j.Name)
+	}
+	id, err := ksuid.Parse(lastID)
+	if err != nil {
+		return time.Time{}, pkgerr.Wrap(err, "parse last processed id")
+	}
+	if id.IsNil() {
+		return time.Time{}, nil
+	}
+	covered := id.Time().Add(-LATE_ENTRY_AGE)
+	if pending.Valid && pending.Time.Before(covered) {
+		covered = pending.Time
+	}
+	return covered, nil
+}
@@ -0,0 +1,333 @@
+// Package rollup maintains tables derived from entries: daily_rollups,
+// pre-computed daily aggregates of entries per (namespace, client_event_type),
+// and first_seen, the first entry time of every user per namespace.
+//
+// Entries are processed incrementally in KSUID order. The id of the last
+// processed entry is kept in rollup_state per job, entries younger than the
+// configured lag are left for the next run so that concurrent appends with
+// slightly smaller ids are not skipped. Entries written with older ids, e.g.
+// replayed from the ingest spool or from rejected_entries, are queued in
+// rollup_pending by the writer and processed apart from the watermark.
+package rollup
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerr "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+
+	"github.com/Bnei-Baruch/chronicles/common"
+	"github.com/Bnei-Baruch/chronicles/models"
+	"github.com/Bnei-Baruch/chronicles/pkg/hll"
+	"github.com/Bnei-Baruch/chronicles/pkg/ksuidutil"
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Names of the jobs in rollup_state.
+const (
+	DAILY_ROLLUPS = "daily_rollups"
+	FIRST_SEEN    = "first_seen"
+)
+
+// Rows per upsert statement, 7 bind parameters each.
+const UPSERT_CHUNK_SIZE = 1000
+
+type Key struct {
+	Day             time.Time
+	Namespace       string
+	ClientEventType string
+}
+
+// Rollup holds the aggregates of entries of a single Key.
+type Rollup struct {
+	Key
+	Events         int64
+	Users          *hll.Sketch
+	IncognitoUsers *hll.Sketch
+	LoggedInUsers  *hll.Sketch
+}
+
+func New(key Key) *Rollup {
+	return &Rollup{
+		Key:            key,
+		Users:          hll.New(),
+		IncognitoUsers: hll.New(),
+		LoggedInUsers:  hll.New(),
+	}
+}
+
+func (r *Rollup) Add(userID string) {
+	r.Events++
+	r.Users.Add(userID)
+	if strings.HasPrefix(userID, common.INCOGNITO_USER_ID_PREFIX) {
+		r.IncognitoUsers.Add(userID)
+	} else if !strings.HasPrefix(userID, common.CLIENT_USER_ID_PREFIX) {
+		r.LoggedInUsers.Add(userID)
+	}
+}
+
+func (r *Rollup) Merge(other *Rollup) {
+	r.Events += other.Events
+	r.Users.Merge(other.Users)
+	r.IncognitoUsers.Merge(other.IncognitoUsers)
+	r.LoggedInUsers.Merge(other.LoggedInUsers)
+}
+
+type rollupRow struct {
+	Day             time.Time `boil:"day"`
+	Namespace       string    `boil:"namespace"`
+	ClientEventType string    `boil:"client_event_type"`
+	Events          int64     `boil:"events"`
+	Users           []byte    `boil:"users"`
+	IncognitoUsers  []byte    `boil:"incognito_users"`
+	LoggedInUsers   []byte    `boil:"logged_in_users"`
+}
+
+func (row *rollupRow) rollup() (*Rollup, error) {
+	r := New(Key{Day: row.Day.UTC(), Namespace: row.Namespace, ClientEventType: row.ClientEventType})
+	r.Events = row.Events
+	if err := r.Users.UnmarshalBinary(row.Users); err != nil {
+		return nil, err
+	}
+	if err := r.IncognitoUsers.UnmarshalBinary(row.IncognitoUsers); err != nil {
+		return nil, err
+	}
+	if err := r.LoggedInUsers.UnmarshalBinary(row.LoggedInUsers); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Rollup) values() ([]interface{}, error) {
+	users, err := r.Users.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	incognitoUsers, err := r.IncognitoUsers.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	loggedInUsers, err := r.LoggedInUsers.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{r.Day, r.Namespace, r.ClientEventType, r.Events, users, incognitoUsers, loggedInUsers}, nil
+}
+
+// Day truncates t to its UTC day.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Job incrementally processes entries in KSUID order, its progress is kept in rollup_state.
+type Job struct {
+	Name string
+	// Processes a batch of entries within the transaction updating the progress.
+	process func(exec boil.Executor, entries models.EntrySlice) error
+}
+
+var (
+	DailyRollups = &Job{Name: DAILY_ROLLUPS, process: rollupEntries}
+	FirstSeen    = &Job{Name: FIRST_SEEN, process: updateFirstSeen}
+
+	Jobs = []*Job{DailyRollups, FirstSeen}
+)
+
+// ProcessBatch processes at most batchSize entries following the last processed
+// one, skipping entries younger than lag (at least MIN_LAG), and then queued late
+// entries. It returns the number of entries processed.
+func (j *Job) ProcessBatch(db *sql.DB, log zerolog.Logger, batchSize int, lag time.Duration) (int, error) {
+	if lag < MIN_LAG {
+		lag = MIN_LAG
+	}
+	processed := 0
+	err := sqlutil.InTx(db, log, func(tx *sql.Tx) error {
+		// Locking the state row serializes concurrent jobs, e.g. several servers.
+		var lastID string
+		if err := tx.QueryRow("SELECT last_id FROM rollup_state WHERE name = $1 FOR UPDATE", j.Name).Scan(&lastID); err != nil {
+			return pkgerr.Wrapf(err, "select %s state", j.Name)
+		}
+
+		entries, err := selectEntries(tx,
+			models.EntryWhere.ID.GT(lastID),
+			models.EntryWhere.ID.LT(ksuidutil.LowerBound(time.Now().Add(-lag))),
+			qm.OrderBy(models.EntryColumns.ID),
+			qm.Limit(batchSize),
+		)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			if err := j.process(tx, entries); err != nil {
+				return err
+			}
+			ids := make([]string, len(entries))
+			for i, e := range entries {
+				ids[i] = e.ID
+			}
+			// Late entries committed before the select are processed now.
+			if err := dequeue(tx, j.Name, ids); err != nil {
+				return err
+			}
+			lastID = ids[len(ids)-1]
+			if _, err := tx.Exec("UPDATE rollup_state SET last_id = $1, updated_at = now() WHERE name = $2", lastID, j.Name); err != nil {
+				return pkgerr.Wrapf(err, "update %s state", j.Name)
+			}
+		}
+
+		// Late entries behind the watermark, committed after it passed them.
+		pending, err := pendingIDs(tx, j.Name, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			late, err := selectEntries(tx, qm.Where("id = ANY(?::text[])", pq.Array(pending)))
+			if err != nil {
+				return err
+			}
+			if len(late) > 0 {
+				if err := j.process(tx, late); err != nil {
+					return err
+				}
+			}
+			// Including ids of entries deleted since.
+			if err := dequeue(tx, j.Name, pending); err != nil {
+				return err
+			}
+		}
+
+		processed = len(entries) + len(pending)
+		return nil
+	})
+	return processed, err
+}
+
+func selectEntries(exec boil.Executor, mods ...qm.QueryMod) (models.EntrySlice, error) {
+	mods = append([]qm.QueryMod{qm.Select(
+		models.EntryColumns.ID,
+		models.EntryColumns.CreatedAt,
+		models.EntryColumns.UserID,
+		models.EntryColumns.Namespace,
+		models.EntryColumns.ClientEventType),
+	}, mods...)
+	entries, err := models.Entries(mods...).All(exec)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "select entries")
+	}
+	return entries, nil
+}
+
+// Run processes entries in batches until it catches up.
+func (j *Job) Run(db *sql.DB, log zerolog.Logger, batchSize int, lag time.Duration) (int, error) {
+	total := 0
+	for {
+		n, err := j.ProcessBatch(db, log, batchSize, lag)
+		total += n
+		if err != nil || n < batchSize {
+			return total, err
+		}
+		log.Debug().Msgf("%s: processed %d entries", j.Name, total)
+	}
+}
+
+func rollupEntries(exec boil.Executor, entries models.EntrySlice) error {
+	rollups := make(map[Key]*Rollup)
+	for _, e := range entries {
+		key := Key{Day: Day(e.CreatedAt), Namespace: e.Namespace, ClientEventType: e.ClientEventType}
+		r, ok := rollups[key]
+		if !ok {
+			r = New(key)
+			rollups[key] = r
+		}
+		r.Add(e.UserID)
+	}
+	return upsert(exec, rollups)
+}
+
+// Merges the rollups with the stored ones and writes them back.
+func upsert(exec boil.Executor, rollups map[Key]*Rollup) error {
+	chunk := make([]*Rollup, 0, UPSERT_CHUNK_SIZE)
+	for _, r := range rollups {
+		chunk = append(chunk, r)
+		if len(chunk) == UPSERT_CHUNK_SIZE {
+			if err := upsertChunk(exec, chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		return upsertChunk(exec, chunk)
+	}
+	return nil
+}
+
+func upsertChunk(exec boil.Executor, chunk []*Rollup) error {
+	var b strings.Builder
+	args := make([]interface{}, 0, 3*len(chunk))
+	b.WriteString("SELECT * FROM daily_rollups WHERE (day, namespace, client_event_type) IN (")
+	for i, r := range chunk {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "($%d::date,$%d,$%d)", len(args)+1, len(args)+2, len(args)+3)
+		args = append(args, r.Day, r.Namespace, r.ClientEventType)
+	}
+	b.WriteString(") FOR UPDATE")
+
+	var rows []*rollupRow
+	if err := queries.Raw(b.String(), args...).Bind(nil, exec, &rows); err != nil {
+		return pkgerr.Wrap(err, "select daily rollups")
+	}
+	stored := make(map[Key]*Rollup, len(rows))
+	for _, row := range rows {
+		r, err := row.rollup()
+		if err != nil {
+			return pkgerr.Wrapf(err, "decode daily rollup %s %s %s", row.Day.Format("2006-01-02"), row.Namespace, row.ClientEventType)
+		}
+		stored[r.Key] = r
+	}
+
+	b.Reset()
+	args = make([]interface{}, 0, 7*len(chunk))
+	b.WriteString("INSERT INTO daily_rollups (day, namespace, client_event_type, events, users, incognito_users, logged_in_users) VALUES ")
+	for i, r := range chunk {
+		if s, ok := stored[r.Key]; ok {
+			r.Merge(s)
+		}
+		values, err := r.values()
+		if err != nil {
+			return pkgerr.Wrap(err, "encode daily rollup")
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('(')
+		for j := range values {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "$%d", len(args)+j+1)
+		}
+		b.WriteByte(')')
+		args = append(args, values...)
+	}
+	b.WriteString(` ON CONFLICT (day, namespace, client_event_type) DO UPDATE SET
+events = EXCLUDED.events,
+users = EXCLUDED.users,
+incognito_users = EXCLUDED.incognito_users,
+logged_in_users = EXCLUDED.logged_in_users`)
+
+	if _, err := exec.Exec(b.String(), args...); err != nil {
+		return pkgerr.Wrap(err, "upsert daily rollups")
+	}
+	return nil
+}
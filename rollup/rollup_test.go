@@ -0,0 +1,62 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type RollupSuite struct {
+	suite.Suite
+}
+
+func TestRollup(t *testing.T) {
+	suite.Run(t, new(RollupSuite))
+}
+
+func (suite *RollupSuite) TestAdd() {
+	r := New(Key{})
+	r.Add("client:local:1")
+	r.Add("client:local:1")
+	r.Add("client:2")
+	r.Add("f7e1f2a4-keycloak")
+
+	suite.EqualValues(4, r.Events)
+	suite.EqualValues(3, r.Users.Estimate())
+	suite.EqualValues(1, r.IncognitoUsers.Estimate())
+	suite.EqualValues(1, r.LoggedInUsers.Estimate())
+
+	other := New(Key{})
+	other.Add("client:local:3")
+	r.Merge(other)
+	suite.EqualValues(5, r.Events)
+	suite.EqualValues(4, r.Users.Estimate())
+	suite.EqualValues(2, r.IncognitoUsers.Estimate())
+}
+
+func (suite *RollupSuite) TestDay() {
+	t := time.Date(2025, 3, 1, 1, 30, 0, 0, time.FixedZone("IST", 2*60*60))
+	suite.Equal(time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC), Day(t))
+}
+
+func (suite *RollupSuite) TestLate() {
+	now := time.Date(2025, 11, 6, 9, 0, 0, 0, time.UTC)
+	old, err := ksuid.NewRandomWithTime(now.Add(-time.Hour))
+	suite.Require().Nil(err)
+	fresh, err := ksuid.NewRandomWithTime(now.Add(-time.Second))
+	suite.Require().Nil(err)
+
+	freshEntry := &models.Entry{ID: fresh.String(), CreatedAt: now.Add(-time.Second)}
+	oldEntry := &models.Entry{ID: old.String(), CreatedAt: now.Add(-time.Hour)}
+	suite.Equal([]string{old.String()}, Late([]*models.Entry{freshEntry, oldEntry}, now))
+	suite.Nil(Late([]*models.Entry{freshEntry}, now))
+
+	// Backdated with an offset.
+	backdated := &models.Entry{ID: fresh.String(), CreatedAt: now.Add(-24 * time.Hour)}
+	suite.Equal([]string{fresh.String()}, Late([]*models.Entry{backdated}, now))
+	suite.True(MIN_LAG > LATE_ENTRY_AGE)
+}
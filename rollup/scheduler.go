@@ -0,0 +1,67 @@
+package rollup
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Scheduler runs all jobs periodically in the background.
+type Scheduler struct {
+	db        *sql.DB
+	interval  time.Duration
+	batchSize int
+	lag       time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewScheduler(db *sql.DB, interval time.Duration, batchSize int, lag time.Duration) *Scheduler {
+	return &Scheduler{
+		db:        db,
+		interval:  interval,
+		batchSize: batchSize,
+		lag:       lag,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the job in the background until Stop is called.
+func (s *Scheduler) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.run()
+			}
+		}
+	}()
+}
+
+// Stop waits for running jobs to complete.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Scheduler) run() {
+	for _, job := range Jobs {
+		start := time.Now()
+		n, err := job.Run(s.db, log.Logger, s.batchSize, s.lag)
+		if err != nil {
+			log.Error().Err(err).Msgf("Job %s", job.Name)
+			continue
+		}
+		if n > 0 {
+			log.Info().Msgf("Job %s processed %d entries in %s", job.Name, n, time.Since(start))
+		}
+	}
+}
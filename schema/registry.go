@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	"github.com/Bnei-Baruch/chronicles/pkg/sqlutil"
+)
+
+// Bounds the memory of lookups of unregistered event types, the cache is reset when full.
+const MAX_CACHED_SCHEMAS = 10000
+
+type cachedSchema struct {
+	schema   *Schema
+	compiled *jsonschema.Schema
+	loadedAt time.Time
+}
+
+// Registry validates data against the latest schema versions. Schemas, and their absence,
+// are cached for ttl so changes take up to ttl to apply on other replicas. While lookups fail
+// with transient errors, e.g., when the DB is down, cached schemas are used past ttl and
+// event types never looked up are not validated.
+type Registry struct {
+	exec  boil.Executor
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]cachedSchema
+}
+
+func NewRegistry(exec boil.Executor, ttl time.Duration) *Registry {
+	return &Registry{
+		exec:  exec,
+		ttl:   ttl,
+		cache: map[string]cachedSchema{},
+	}
+}
+
+func cacheKey(namespace, clientEventType string) string {
+	return namespace + "\x00" + clientEventType
+}
+
+// Validate validates data against the latest schema of the event type in the namespace.
+// Returns a *ViolationError, of the schema's mode, when data doesn't match.
+// Event types without a schema, or with mode off, are not validated.
+func (r *Registry) Validate(namespace, clientEventType string, data []byte) error {
+	cached, err := r.latest(namespace, clientEventType)
+	if err != nil {
+		return err
+	}
+	if cached.schema == nil || cached.schema.Mode == MODE_OFF {
+		return nil
+	}
+	pointer, message, err := Validate(cached.compiled, data)
+	if err != nil || message == "" {
+		return err
+	}
+	return &ViolationError{
+		Namespace:       namespace,
+		ClientEventType: clientEventType,
+		Version:         cached.schema.Version,
+		Mode:            cached.schema.Mode,
+		Pointer:         pointer,
+		Message:         message,
+	}
+}
+
+// Forget drops the cached schema, after it was changed.
+func (r *Registry) Forget(namespace, clientEventType string) {
+	r.mu.Lock()
+	delete(r.cache, cacheKey(namespace, clientEventType))
+	r.mu.Unlock()
+}
+
+func (r *Registry) latest(namespace, clientEventType string) (cachedSchema, error) {
+	key := cacheKey(namespace, clientEventType)
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < r.ttl {
+		return cached, nil
+	}
+
+	s, err := Find(r.exec, namespace, clientEventType, 0)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		if sqlutil.IsTransient(err) {
+			return cached, nil
+		}
+		return cached, err
+	}
+	cached = cachedSchema{loadedAt: now}
+	if s != nil {
+		compiled, err := Compile(s.Schema.JSON)
+		if err != nil {
+			return cached, err
+		}
+		cached.schema, cached.compiled = s, compiled
+	}
+	r.mu.Lock()
+	if len(r.cache) >= MAX_CACHED_SCHEMAS {
+		r.cache = map[string]cachedSchema{}
+	}
+	r.cache[key] = cached
+	r.mu.Unlock()
+	return cached, nil
+}
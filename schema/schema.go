@@ -0,0 +1,186 @@
+// Package schema is a registry of JSON Schemas of the data of entries per
+// (namespace, client_event_type). Registering a schema adds a new version,
+// appends are validated against the latest version by its mode: enforce
+// rejects entries not matching it, warn only logs them and off skips validation.
+package schema
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	pkgerr "github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+const (
+	MODE_ENFORCE = "enforce"
+	MODE_WARN    = "warn"
+	MODE_OFF     = "off"
+)
+
+var (
+	ErrNotFound    = errors.New("schema not found")
+	ErrInvalidMode = errors.New("expected mode to be enforce, warn or off")
+)
+
+type Schema struct {
+	Namespace       string    `boil:"namespace" json:"namespace"`
+	ClientEventType string    `boil:"client_event_type" json:"client_event_type"`
+	Version         int       `boil:"version" json:"version"`
+	Schema          null.JSON `boil:"schema" json:"schema"`
+	Mode            string    `boil:"mode" json:"mode"`
+	CreatedAt       time.Time `boil:"created_at" json:"created_at"`
+}
+
+// ViolationError tells where data doesn't match a schema.
+type ViolationError struct {
+	Namespace       string
+	ClientEventType string
+	Version         int
+	Mode            string
+	// JSON pointer of the failing value in data, empty for data itself.
+	Pointer string
+	Message string
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("data does not match schema version %d of %s in %s at %q: %s",
+		e.Version, e.ClientEventType, e.Namespace, e.Pointer, e.Message)
+}
+
+// ErrorDetails are added to error responses.
+func (e *ViolationError) ErrorDetails() map[string]interface{} {
+	return map[string]interface{}{
+		"pointer":        e.Pointer,
+		"schema_version": e.Version,
+	}
+}
+
+func ValidMode(mode string) bool {
+	return mode == MODE_ENFORCE || mode == MODE_WARN || mode == MODE_OFF
+}
+
+// Compile compiles a JSON Schema, drafts 4 to 2020-12. References to other documents
+// are not loaded, schemas must be self contained.
+func Compile(raw []byte) (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("loading %s is not supported", url)
+	}
+	if err := c.AddResource("schema.json", bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return c.Compile("schema.json")
+}
+
+// Validate validates data, null when empty, against the compiled schema. Violations are
+// reported by the first failing value.
+func Validate(s *jsonschema.Schema, data []byte) (string, string, error) {
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return "", "", pkgerr.Wrap(err, "decode data")
+	}
+	err := s.Validate(v)
+	if err == nil {
+		return "", "", nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return "", "", err
+	}
+	for len(ve.Causes) > 0 {
+		ve = ve.Causes[0]
+	}
+	return ve.InstanceLocation, ve.Message, nil
+}
+
+// Register compiles the schema and stores it as the next version. Pass a transaction,
+// concurrent registrations of the schema wait for it to end to take the following version.
+func Register(exec boil.Executor, namespace, clientEventType string, raw []byte, mode string) (*Schema, error) {
+	if !ValidMode(mode) {
+		return nil, ErrInvalidMode
+	}
+	if _, err := Compile(raw); err != nil {
+		return nil, err
+	}
+	if _, err := exec.Exec("SELECT pg_advisory_xact_lock(hashtext('event_schemas:' || $1 || ':' || $2))",
+		namespace, clientEventType); err != nil {
+		return nil, pkgerr.Wrap(err, "lock event schema")
+	}
+	s := &Schema{}
+	err := queries.Raw(`INSERT INTO event_schemas (namespace, client_event_type, version, schema, mode)
+SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4 FROM event_schemas WHERE namespace = $1 AND client_event_type = $2
+RETURNING *`, namespace, clientEventType, string(raw), mode).Bind(nil, exec, s)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "insert event schema")
+	}
+	return s, nil
+}
+
+// Latest returns the latest version of each schema, of the namespace unless empty.
+func Latest(exec boil.Executor, namespace string) ([]*Schema, error) {
+	schemas := []*Schema{}
+	err := queries.Raw(`SELECT DISTINCT ON (namespace, client_event_type) * FROM event_schemas
+WHERE $1 = '' OR namespace = $1
+ORDER BY namespace, client_event_type, version DESC`, namespace).Bind(nil, exec, &schemas)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "select event schemas")
+	}
+	return schemas, nil
+}
+
+// Versions returns all versions of the schema, latest first.
+func Versions(exec boil.Executor, namespace, clientEventType string) ([]*Schema, error) {
+	schemas := []*Schema{}
+	err := queries.Raw(`SELECT * FROM event_schemas WHERE namespace = $1 AND client_event_type = $2
+ORDER BY version DESC`, namespace, clientEventType).Bind(nil, exec, &schemas)
+	if err != nil {
+		return nil, pkgerr.Wrap(err, "select event schema versions")
+	}
+	return schemas, nil
+}
+
+// Find returns the latest version when version is 0.
+func Find(exec boil.Executor, namespace, clientEventType string, version int) (*Schema, error) {
+	s := &Schema{}
+	err := queries.Raw(`SELECT * FROM event_schemas WHERE namespace = $1 AND client_event_type = $2 AND ($3 = 0 OR version = $3)
+ORDER BY version DESC LIMIT 1`, namespace, clientEventType, version).Bind(nil, exec, s)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, pkgerr.Wrap(err, "select event schema")
+	}
+	return s, nil
+}
+
+// SetMode changes the mode of a version.
+func SetMode(exec boil.Executor, namespace, clientEventType string, version int, mode string) (*Schema, error) {
+	if !ValidMode(mode) {
+		return nil, ErrInvalidMode
+	}
+	s := &Schema{}
+	err := queries.Raw(`UPDATE event_schemas SET mode = $4 WHERE namespace = $1 AND client_event_type = $2 AND version = $3
+RETURNING *`, namespace, clientEventType, version, mode).Bind(nil, exec, s)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, pkgerr.Wrap(err, "update event schema mode")
+	}
+	return s, nil
+}
@@ -0,0 +1,152 @@
+package schema
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+)
+
+const playerSchema = `{
+  "type": "object",
+  "required": ["unit_uid"],
+  "properties": {
+    "unit_uid": {"type": "string", "minLength": 8},
+    "position": {"type": "number", "minimum": 0},
+    "tags": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+type SchemaSuite struct {
+	suite.Suite
+}
+
+func TestSchema(t *testing.T) {
+	suite.Run(t, new(SchemaSuite))
+}
+
+func (suite *SchemaSuite) TestCompile() {
+	_, err := Compile([]byte(playerSchema))
+	suite.Nil(err)
+
+	for _, invalid := range []string{``, `{`, `{"type": "nope"}`, `{"$ref": "https://example.com/schema.json"}`} {
+		_, err := Compile([]byte(invalid))
+		suite.NotNil(err, invalid)
+	}
+}
+
+func (suite *SchemaSuite) TestValidate() {
+	compiled, err := Compile([]byte(playerSchema))
+	suite.Require().Nil(err)
+
+	for _, tc := range []struct {
+		data    string
+		pointer string
+		valid   bool
+	}{
+		{data: `{"unit_uid": "abcdefgh", "position": 12.5, "tags": ["a"]}`, valid: true},
+		{data: `{"unit_uid": "abcdefgh", "position": 12345678901234567890}`, valid: true},
+		{data: `{"unit_uid": "abcdefgh", "position": -1}`, pointer: "/position"},
+		{data: `{"unit_uid": "abc"}`, pointer: "/unit_uid"},
+		{data: `{"unit_uid": "abcdefgh", "tags": ["a", 1]}`, pointer: "/tags/1"},
+		{data: `{"position": 1}`, pointer: ""},
+		{data: ``, pointer: ""},
+	} {
+		pointer, message, err := Validate(compiled, []byte(tc.data))
+		suite.Require().Nil(err, tc.data)
+		if tc.valid {
+			suite.Empty(message, tc.data)
+			continue
+		}
+		suite.NotEmpty(message, tc.data)
+		suite.Equal(tc.pointer, pointer, tc.data)
+	}
+
+	_, _, err = Validate(compiled, []byte(`{"unit_uid"`))
+	suite.NotNil(err)
+}
+
+func (suite *SchemaSuite) TestRegistry() {
+	registry := NewRegistry(nil, time.Hour)
+	compiled, err := Compile([]byte(playerSchema))
+	suite.Require().Nil(err)
+	cache := func(mode string) {
+		registry.cache[cacheKey("archive", "player-play")] = cachedSchema{
+			schema:   &Schema{Namespace: "archive", ClientEventType: "player-play", Version: 3, Schema: null.JSONFrom([]byte(playerSchema)), Mode: mode},
+			compiled: compiled,
+			loadedAt: time.Now(),
+		}
+	}
+	registry.cache[cacheKey("archive", "page-view")] = cachedSchema{loadedAt: time.Now()}
+
+	cache(MODE_ENFORCE)
+	suite.Nil(registry.Validate("archive", "player-play", []byte(`{"unit_uid": "abcdefgh"}`)))
+	suite.Nil(registry.Validate("archive", "page-view", []byte(`{"unit_uid": 1}`)))
+
+	err = registry.Validate("archive", "player-play", []byte(`{"unit_uid": "abcdefgh", "position": -1}`))
+	var violation *ViolationError
+	suite.Require().True(errors.As(err, &violation))
+	suite.Equal(MODE_ENFORCE, violation.Mode)
+	suite.Equal(3, violation.Version)
+	suite.Equal("/position", violation.Pointer)
+	suite.Equal(map[string]interface{}{"pointer": "/position", "schema_version": 3}, violation.ErrorDetails())
+	suite.Contains(violation.Error(), `schema version 3 of player-play in archive at "/position"`)
+
+	cache(MODE_WARN)
+	err = registry.Validate("archive", "player-play", []byte(`{}`))
+	suite.Require().True(errors.As(err, &violation))
+	suite.Equal(MODE_WARN, violation.Mode)
+
+	cache(MODE_OFF)
+	suite.Nil(registry.Validate("archive", "player-play", []byte(`{}`)))
+
+	registry.Forget("archive", "player-play")
+	suite.NotContains(registry.cache, cacheKey("archive", "player-play"))
+	suite.Contains(registry.cache, cacheKey("archive", "page-view"))
+}
+
+// Fails every statement like a DB that is down.
+type downExecutor struct{}
+
+func (downExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (downExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (downExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	panic("unexpected QueryRow")
+}
+
+func (suite *SchemaSuite) TestRegistryStale() {
+	registry := NewRegistry(downExecutor{}, time.Minute)
+	compiled, err := Compile([]byte(playerSchema))
+	suite.Require().Nil(err)
+	registry.cache[cacheKey("archive", "player-play")] = cachedSchema{
+		schema:   &Schema{Namespace: "archive", ClientEventType: "player-play", Version: 1, Schema: null.JSONFrom([]byte(playerSchema)), Mode: MODE_ENFORCE},
+		compiled: compiled,
+		loadedAt: time.Now().Add(-time.Hour),
+	}
+
+	// Expired schemas are used while the DB is down.
+	var violation *ViolationError
+	suite.True(errors.As(registry.Validate("archive", "player-play", []byte(`{}`)), &violation))
+
+	// Event types never looked up are not validated, nor cached.
+	suite.Nil(registry.Validate("archive", "search", []byte(`{}`)))
+	suite.NotContains(registry.cache, cacheKey("archive", "search"))
+}
+
+func (suite *SchemaSuite) TestValidMode() {
+	suite.True(ValidMode(MODE_ENFORCE))
+	suite.True(ValidMode(MODE_WARN))
+	suite.True(ValidMode(MODE_OFF))
+	suite.False(ValidMode(""))
+	suite.False(ValidMode("strict"))
+}
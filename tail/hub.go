@@ -0,0 +1,124 @@
+// Package tail fans out newly appended entries to live subscribers.
+package tail
+
+import (
+	"sync"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+// Hub delivers published entries to the subscribers they match.
+// Subscribers not keeping up, i.e., with a full buffer, are dropped.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+type Subscription struct {
+	hub     *Hub
+	match   func(*models.Entry) bool
+	entries chan *models.Entry
+	done    chan struct{}
+	once    sync.Once
+
+	// Set before done is closed.
+	dropped bool
+}
+
+// Subscribe registers a subscriber of entries matching match, buffering up to buffer entries.
+func (h *Hub) Subscribe(match func(*models.Entry) bool, buffer int) *Subscription {
+	s := &Subscription{
+		hub:     h,
+		match:   match,
+		entries: make(chan *models.Entry, buffer),
+		done:    make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Publish delivers the entries without blocking.
+func (h *Hub) Publish(entries []*models.Entry) {
+	var slow []*Subscription
+	h.mu.RLock()
+	for s := range h.subscribers {
+		if !s.deliver(entries) {
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		s.close(true)
+	}
+}
+
+// Close closes all subscriptions, e.g., on shutdown.
+func (h *Hub) Close() {
+	h.mu.RLock()
+	subscribers := make([]*Subscription, 0, len(h.subscribers))
+	for s := range h.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	h.mu.RUnlock()
+
+	for _, s := range subscribers {
+		s.Close()
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Returns false when the buffer is full.
+func (s *Subscription) deliver(entries []*models.Entry) bool {
+	for _, e := range entries {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.entries <- e:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Subscription) Entries() <-chan *models.Entry {
+	return s.entries
+}
+
+// Done is closed once the subscription is closed or dropped.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped tells, once Done is closed, whether the subscriber was dropped for being slow.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+func (s *Subscription) Close() {
+	s.close(false)
+}
+
+func (s *Subscription) close(dropped bool) {
+	s.once.Do(func() {
+		s.hub.mu.Lock()
+		delete(s.hub.subscribers, s)
+		s.hub.mu.Unlock()
+		s.dropped = dropped
+		close(s.done)
+	})
+}
@@ -0,0 +1,77 @@
+package tail
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+type HubSuite struct {
+	suite.Suite
+}
+
+func TestHub(t *testing.T) {
+	suite.Run(t, new(HubSuite))
+}
+
+func byNamespace(namespace string) func(*models.Entry) bool {
+	return func(e *models.Entry) bool { return e.Namespace == namespace }
+}
+
+func (suite *HubSuite) TestPublish() {
+	hub := NewHub()
+	archive := hub.Subscribe(byNamespace("archive"), 10)
+	defer archive.Close()
+	kmedia := hub.Subscribe(byNamespace("kmedia"), 10)
+	defer kmedia.Close()
+	suite.Equal(2, hub.Subscribers())
+
+	hub.Publish([]*models.Entry{{ID: "1", Namespace: "archive"}, {ID: "2", Namespace: "kmedia"}, {ID: "3", Namespace: "archive"}})
+	suite.Equal("1", (<-archive.Entries()).ID)
+	suite.Equal("3", (<-archive.Entries()).ID)
+	suite.Equal("2", (<-kmedia.Entries()).ID)
+	suite.Len(archive.Entries(), 0)
+}
+
+func (suite *HubSuite) TestSlowConsumer() {
+	hub := NewHub()
+	slow := hub.Subscribe(byNamespace("archive"), 1)
+	fast := hub.Subscribe(byNamespace("archive"), 10)
+	defer fast.Close()
+
+	hub.Publish([]*models.Entry{{ID: "1", Namespace: "archive"}, {ID: "2", Namespace: "archive"}})
+	<-slow.Done()
+	suite.True(slow.Dropped())
+	suite.Len(fast.Entries(), 2)
+	suite.Equal(1, hub.Subscribers())
+}
+
+func (suite *HubSuite) TestClose() {
+	hub := NewHub()
+	sub := hub.Subscribe(byNamespace("archive"), 1)
+	sub.Close()
+	sub.Close()
+	<-sub.Done()
+	suite.False(sub.Dropped())
+	suite.Equal(0, hub.Subscribers())
+
+	other := hub.Subscribe(byNamespace("archive"), 1)
+	hub.Close()
+	<-other.Done()
+	suite.False(other.Dropped())
+}
+
+func (suite *HubSuite) TestNotificationPayload() {
+	data := fmt.Sprintf(`{"query": "%s"}`, strings.Repeat("a", MAX_NOTIFY_PAYLOAD))
+	entry := &models.Entry{ID: "1", Namespace: "archive", Data: null.JSONFrom([]byte(data))}
+	payload, err := notificationPayload("replica", entry)
+	suite.Require().Nil(err)
+	suite.Less(len(payload), MAX_NOTIFY_PAYLOAD)
+	suite.Contains(payload, `"origin":"replica"`)
+	suite.True(entry.Data.Valid)
+}
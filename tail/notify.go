@@ -0,0 +1,155 @@
+package tail
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/volatiletech/null/v8"
+
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+const (
+	NOTIFY_CHANNEL = "chronicles_tail"
+	// Postgres limits NOTIFY payloads to 8000 bytes,
+	// data of entries with larger payloads is not sent to other replicas.
+	MAX_NOTIFY_PAYLOAD = 7900
+	NOTIFY_QUEUE_SIZE  = 1000
+)
+
+type notification struct {
+	// Replicas skip their own notifications, these were already published.
+	Origin string        `json:"origin"`
+	Entry  *models.Entry `json:"entry"`
+}
+
+func notificationPayload(origin string, entry *models.Entry) (string, error) {
+	b, err := json.Marshal(notification{Origin: origin, Entry: entry})
+	if err != nil {
+		return "", err
+	}
+	if len(b) > MAX_NOTIFY_PAYLOAD {
+		withoutData := *entry
+		withoutData.Data = null.JSON{}
+		if b, err = json.Marshal(notification{Origin: origin, Entry: &withoutData}); err != nil {
+			return "", err
+		}
+	}
+	return string(b), nil
+}
+
+// Notifier sends entries to other replicas with Postgres NOTIFY, in the background.
+type Notifier struct {
+	db     *sql.DB
+	origin string
+	queue  chan []*models.Entry
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewNotifier(db *sql.DB, origin string) *Notifier {
+	return &Notifier{
+		db:     db,
+		origin: origin,
+		queue:  make(chan []*models.Entry, NOTIFY_QUEUE_SIZE),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (n *Notifier) Start() {
+	go func() {
+		defer close(n.done)
+		for {
+			select {
+			case <-n.stop:
+				return
+			case entries := <-n.queue:
+				if err := n.notify(entries); err != nil {
+					log.Error().Err(err).Msg("Tail notify")
+				}
+			}
+		}
+	}()
+}
+
+func (n *Notifier) Stop() {
+	close(n.stop)
+	<-n.done
+}
+
+// Notify queues the entries without blocking, these are dropped if the queue is full.
+func (n *Notifier) Notify(entries []*models.Entry) {
+	select {
+	case n.queue <- entries:
+	default:
+		log.Warn().Msgf("Tail notify queue is full, dropped %d entries", len(entries))
+	}
+}
+
+func (n *Notifier) notify(entries []*models.Entry) error {
+	payloads := make([]string, len(entries))
+	for i, entry := range entries {
+		payload, err := notificationPayload(n.origin, entry)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+	_, err := n.db.Exec("SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload", NOTIFY_CHANNEL, pq.Array(payloads))
+	return err
+}
+
+// Listener publishes entries notified by other replicas to the hub.
+type Listener struct {
+	listener *pq.Listener
+	hub      *Hub
+	origin   string
+	done     chan struct{}
+}
+
+func Listen(dbURL string, hub *Hub, origin string) (*Listener, error) {
+	pl := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error().Err(err).Msg("Tail listener")
+		}
+	})
+	if err := pl.Listen(NOTIFY_CHANNEL); err != nil {
+		pl.Close()
+		return nil, err
+	}
+
+	l := &Listener{listener: pl, hub: hub, origin: origin, done: make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+func (l *Listener) run() {
+	defer close(l.done)
+	// Closed by Stop.
+	for n := range l.listener.Notify {
+		// Nil after reconnecting, notifications in between are lost.
+		if n == nil {
+			continue
+		}
+		var msg notification
+		if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+			log.Error().Err(err).Msg("Tail listener: invalid notification")
+			continue
+		}
+		if msg.Origin == l.origin || msg.Entry == nil {
+			continue
+		}
+		l.hub.Publish([]*models.Entry{msg.Entry})
+	}
+}
+
+func (l *Listener) Stop() {
+	if err := l.listener.Close(); err != nil {
+		log.Error().Err(err).Msg("Tail listener close")
+	}
+	<-l.done
+}
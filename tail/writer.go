@@ -0,0 +1,31 @@
+package tail
+
+import (
+	"github.com/rs/zerolog"
+
+	"github.com/Bnei-Baruch/chronicles/ingest"
+	"github.com/Bnei-Baruch/chronicles/models"
+)
+
+// Writer publishes the entries written by the wrapped writer to the hub
+// and, if set, to other replicas with the notifier.
+type Writer struct {
+	ingest.Writer
+	hub      *Hub
+	notifier *Notifier
+}
+
+func NewWriter(writer ingest.Writer, hub *Hub, notifier *Notifier) *Writer {
+	return &Writer{Writer: writer, hub: hub, notifier: notifier}
+}
+
+func (w *Writer) Write(log zerolog.Logger, entries []*models.Entry) error {
+	if err := w.Writer.Write(log, entries); err != nil {
+		return err
+	}
+	w.hub.Publish(entries)
+	if w.notifier != nil {
+		w.notifier.Notify(entries)
+	}
+	return nil
+}